@@ -0,0 +1,181 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"encoding/json"
+	. "fmt"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditOutcome tells whether an audited operation succeeded.
+type AuditOutcome string
+
+const (
+	AuditOutcomeSuccess AuditOutcome = "success"
+	AuditOutcomeFailure AuditOutcome = "failure"
+)
+
+// AuditEvent records a single mutation of a key: Store, Delete, Lock, Unlock, Rollback, Undelete, DestroyVersions (one
+// event per version), or the rewrite of a key by Migrate or RewrapTransit.  Version is the Vault version written (by
+// Store, Lock, Rollback, Migrate and RewrapTransit), removed (the latest version when Delete was called, or the
+// destroyed version) or restored (by Undelete).  It is 0 for Unlock, or when it isn't known.
+type AuditEvent struct {
+	Time       time.Time    `json:"time"`
+	Operation  Operation    `json:"operation"`
+	Key        string       `json:"key"`
+	Version    int          `json:"version,omitempty"`
+	InstanceID string       `json:"instance_id"`
+	Outcome    AuditOutcome `json:"outcome"`
+	ErrorClass ErrorClass   `json:"error_class,omitempty"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// AuditSink receives an AuditEvent for every mutation made through Storage.  It is called inline, after the operation
+// completed, and must be safe for concurrent use.  Errors are logged, they don't fail the operation.
+type AuditSink interface {
+	Audit(ctx context.Context, event AuditEvent) error
+}
+
+// AuditConfigInterface can optionally be implemented by a StorageConfigInterface to audit mutations.  GetInstanceID()
+// identifies this instance in the events, and defaults to "<hostname>/<pid>" when empty.
+type AuditConfigInterface interface {
+	GetAuditSink() AuditSink
+	GetInstanceID() string
+}
+
+func (s *Storage) auditConfig() (AuditSink, string) {
+	config, ok := s.config.(AuditConfigInterface)
+	if !ok || config.GetAuditSink() == nil {
+		return nil, ""
+	}
+
+	if config.GetInstanceID() != "" {
+		return config.GetAuditSink(), config.GetInstanceID()
+	}
+
+	hostname, _ := os.Hostname()
+	return config.GetAuditSink(), Sprintf("%s/%d", hostname, os.Getpid())
+}
+
+// audit is meant to be deferred at the start of a mutation, with pointers to the version it affects and its named error
+// result
+func (s *Storage) audit(ctx context.Context, operation Operation, key string, version *int, err *error) {
+	sink, instanceID := s.auditConfig()
+	if sink == nil {
+		return
+	}
+
+	event := AuditEvent{
+		Time:       time.Now().UTC(),
+		Operation:  operation,
+		Key:        key,
+		Version:    *version,
+		InstanceID: instanceID,
+		Outcome:    AuditOutcomeSuccess,
+	}
+	if *err != nil {
+		event.Outcome = AuditOutcomeFailure
+		event.ErrorClass = ClassifyError(*err)
+		event.Error = (*err).Error()
+	}
+
+	if auditErr := sink.Audit(ctx, event); auditErr != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to write audit event",
+			"operation", operation,
+			"key", key,
+			"error", auditErr.Error(),
+		)
+	}
+}
+
+// auditedVersion returns the latest version of key, for an audit event about a mutation that doesn't return it.  It
+// is only looked up when auditing is enabled, and 0 when it can't be.
+func (s *Storage) auditedVersion(ctx context.Context, key string) int {
+	if sink, _ := s.auditConfig(); sink == nil {
+		return 0
+	}
+
+	meta, err := s.readMetadata(ctx, key)
+	if err != nil {
+		return 0
+	}
+
+	return meta.Data.CurrentVersion
+}
+
+// JSONLinesAuditSink writes every AuditEvent as a line of JSON.
+type JSONLinesAuditSink struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewJSONLinesAuditSink returns a sink writing to writer
+func NewJSONLinesAuditSink(writer io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{writer: writer}
+}
+
+// OpenJSONLinesAuditSink returns a sink appending to the file at path, which is created if needed.  The caller is
+// responsible for closing the file with Close.
+func OpenJSONLinesAuditSink(path string) (*JSONLinesAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewJSONLinesAuditSink(file), nil
+}
+
+func (a *JSONLinesAuditSink) Audit(_ context.Context, event AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	_, err = a.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the underlying writer, if it can be closed
+func (a *JSONLinesAuditSink) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if closer, ok := a.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
+
+// ZapAuditSink logs every AuditEvent at info level, typically to the logger returned by GetLogger().
+type ZapAuditSink struct {
+	logger *zap.SugaredLogger
+}
+
+func NewZapAuditSink(logger *zap.SugaredLogger) *ZapAuditSink {
+	return &ZapAuditSink{logger: logger}
+}
+
+func (a *ZapAuditSink) Audit(_ context.Context, event AuditEvent) error {
+	a.logger.Infow(
+		"Audit",
+		"time", event.Time,
+		"operation", event.Operation,
+		"key", event.Key,
+		"version", event.Version,
+		"instance_id", event.InstanceID,
+		"outcome", event.Outcome,
+		"error_class", event.ErrorClass,
+		"error", event.Error,
+	)
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+type recordingAuditSink struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+func (r *recordingAuditSink) Audit(_ context.Context, event AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = append(r.events, event)
+	return nil
+}
+
+// take returns the "<operation>@<version>" of the events recorded since it was last called
+func (r *recordingAuditSink) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	taken := make([]string, 0, len(r.events))
+	for _, event := range r.events {
+		taken = append(taken, string(event.Operation)+"@"+strconv.Itoa(event.Version))
+	}
+	r.events = nil
+
+	return taken
+}
+
+func TestAuditVersions(t *testing.T) {
+	ctx := context.Background()
+	sink := &recordingAuditSink{}
+	config := &Config{AuditSink: sink, InstanceID: "test"}
+	storage, vault := newTestStorage(t, config)
+
+	key := "certificates/example.com/example.com.crt"
+	steps := []struct {
+		name string
+		run  func() error
+		want []string
+	}{
+		{"store", func() error { return storage.Store(ctx, key, []byte("v1")) }, []string{"store@1"}},
+		{"store again", func() error { return storage.Store(ctx, key, []byte("v2")) }, []string{"store@2"}},
+		{"rollback", func() error { return storage.Rollback(ctx, key, 1) }, []string{"rollback@3"}},
+		{"soft delete", func() error { config.DeleteMode = DeleteModeSoft; return storage.Delete(ctx, key) }, []string{"delete@3"}},
+		{"undelete", func() error { return storage.Undelete(ctx, key) }, []string{"undelete@3"}},
+		{"destroy", func() error { config.DeleteMode = DeleteModeDestroy; return storage.Delete(ctx, key) }, []string{"delete@3"}},
+		{"destroy versions", func() error { return storage.DestroyVersions(ctx, key, 1, 2) }, []string{"destroy_versions@1", "destroy_versions@2"}},
+		{"rewrap", func() error {
+			config.TransitKeyName = "certs"
+			if err := storage.Store(ctx, key, []byte("v4")); err != nil {
+				return err
+			}
+			vault.RotateTransit()
+			_, err := storage.RewrapTransit(ctx)
+			return err
+		}, []string{"store@4", "rewrap_transit@5"}},
+		{"metadata delete", func() error { config.DeleteMode = DeleteModeMetadata; return storage.Delete(ctx, key) }, []string{"delete@5"}},
+	}
+
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		got := sink.take()
+		if len(got) != len(step.want) {
+			t.Fatalf("%s audited %q, want %q", step.name, got, step.want)
+		}
+		for i := range got {
+			if got[i] != step.want[i] {
+				t.Errorf("%s audited %q, want %q", step.name, got, step.want)
+				break
+			}
+		}
+	}
+}
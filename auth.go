@@ -1,6 +1,7 @@
 package certmagic_vault_storage
 
 import (
+	"context"
 	. "fmt"
 	"github.com/dustin/go-humanize"
 	"github.com/pkg/errors"
@@ -51,7 +52,7 @@ type approleLoginInput struct {
 }
 
 // getToken prefers to return a static 'Token' value, otherwise it returns the approle token
-func (s *Storage) getToken() string {
+func (s *Storage) getToken(ctx context.Context) string {
 	if s.config.GetToken() != "" {
 		s.logger.Debug("Using static Vault token for auth")
 		return s.config.GetToken()
@@ -60,6 +61,7 @@ func (s *Storage) getToken() string {
 	if s.approleResponse != nil {
 		if !s.approleTokenExpired() {
 			s.logger.Debug("Using approle client token for auth")
+			s.metrics().SetTokenTTL(time.Until(*s.approleTokenExpiration))
 			return s.approleResponse.Auth.ClientToken
 		} else {
 			s.logger.Warnw("Approle client token expired",
@@ -68,7 +70,7 @@ func (s *Storage) getToken() string {
 		}
 	}
 
-	if err := s.login(); err != nil {
+	if err := s.login(ctx); err != nil {
 		return ""
 	}
 
@@ -76,12 +78,14 @@ func (s *Storage) getToken() string {
 	return s.approleResponse.Auth.ClientToken
 }
 
-func (s *Storage) login() error {
+func (s *Storage) login(ctx context.Context) (err error) {
+	defer func(renewal bool) { s.metrics().ObserveLogin(renewal, ClassifyError(err)) }(s.approleResponse != nil)
+
 	s.logger.Info("Logging in to vault using approle credentials")
 	result := &successResponse{}
 	errResponse := &errorResponse{}
 	body := &approleLoginInput{RoleId: s.config.GetApproleRoleId(), SecretId: s.config.GetApproleSecretId()}
-	response, err := s.client.SetHostUrl(s.config.GetVaultBaseUrl()).ApproleLogin(s.config.GetApproleLoginPath(), body, result, errResponse)
+	response, err := s.client.SetHostUrl(s.config.GetVaultBaseUrl()).ApproleLogin(ctx, s.config.GetApproleLoginPath(), body, result, errResponse)
 	if err != nil {
 		s.logger.Errorw(
 			"[ERROR] during vault login using approle credentials",
@@ -89,9 +93,9 @@ func (s *Storage) login() error {
 			"error", err.Error(),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", response.StatusCode(),
-			"response_body", response.String(),
+			"response_body", s.responseBody(response),
 		)
-		return err
+		return newVaultError(s.config.GetApproleLoginPath(), response, errResponse, err)
 	}
 
 	if response.IsError() {
@@ -100,19 +104,20 @@ func (s *Storage) login() error {
 			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.config.GetApproleLoginPath()),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", response.StatusCode(),
-			"response_body", response.String(),
+			"response_body", s.responseBody(response),
 		)
-		return errResponse.Error()
+		return newVaultError(s.config.GetApproleLoginPath(), response, errResponse, nil)
 	}
 
 	s.approleResponse = result
 	expiration := time.Now().Add(time.Duration(result.Auth.LeaseDuration) * time.Second)
 	s.approleTokenExpiration = &expiration
+	s.metrics().SetTokenTTL(time.Until(expiration))
 
 	return nil
 }
 
-func (s *Storage) logout() error {
+func (s *Storage) logout(ctx context.Context) error {
 	// If we do not have a valid approleResponse, this is a noop
 	if s.approleResponse == nil {
 		return nil
@@ -121,7 +126,7 @@ func (s *Storage) logout() error {
 	body := &struct{}{}
 	result := &successResponse{}
 	errResponse := &errorResponse{}
-	response, err := s.client.SetHostUrl(s.config.GetVaultBaseUrl()).ApproleLogout(s.getToken(), s.config.GetApproleLogoutPath(), body, result, errResponse)
+	response, err := s.client.SetHostUrl(s.config.GetVaultBaseUrl()).ApproleLogout(ctx, s.getToken(ctx), s.config.GetApproleLogoutPath(), body, result, errResponse)
 	if err != nil {
 		s.logger.Errorw(
 			"[ERROR] during vault login using approle credentials",
@@ -129,9 +134,9 @@ func (s *Storage) logout() error {
 			"error", err.Error(),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", response.StatusCode(),
-			"response_body", response.String(),
+			"response_body", s.responseBody(response),
 		)
-		return err
+		return newVaultError(s.config.GetApproleLogoutPath(), response, errResponse, err)
 	}
 
 	if response.IsError() {
@@ -140,9 +145,9 @@ func (s *Storage) logout() error {
 			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.config.GetApproleLoginPath()),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", response.StatusCode(),
-			"response_body", response.String(),
+			"response_body", s.responseBody(response),
 		)
-		return errResponse.Error()
+		return newVaultError(s.config.GetApproleLogoutPath(), response, errResponse, nil)
 	}
 
 	s.approleResponse = nil
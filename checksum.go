@@ -0,0 +1,46 @@
+package certmagic_vault_storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	. "fmt"
+)
+
+// ErrCorrupted is matched (with errors.Is) by every ChecksumError.
+var ErrCorrupted = errors.New("stored value is corrupted")
+
+// ChecksumError is returned by Load when the value read from Vault doesn't match the SHA-256 recorded by Store, e.g.
+// because the entry was truncated or edited by hand.
+type ChecksumError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumError) Error() string {
+	return Sprintf("%s: %s: expected sha256 %s, got %s", ErrCorrupted.Error(), e.Key, e.Expected, e.Actual)
+}
+
+func (e *ChecksumError) Unwrap() error {
+	return ErrCorrupted
+}
+
+func checksum(value []byte) string {
+	sum := sha256.Sum256(value)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyChecksum compares value against the expected checksum, secrets written before checksums existed have none and
+// always pass
+func verifyChecksum(key, expected string, value []byte) error {
+	if expected == "" {
+		return nil
+	}
+
+	if actual := checksum(value); actual != expected {
+		return &ChecksumError{Key: key, Expected: expected, Actual: actual}
+	}
+
+	return nil
+}
@@ -0,0 +1,142 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	. "fmt"
+	"io/fs"
+	"strings"
+)
+
+// chunksSuffix is appended to a key to form the "directory" its chunks are stored in, i.e. "<key>.chunks/<index>".
+// List hides these directories, so a chunked value shows up as a single key.
+const chunksSuffix = ".chunks"
+
+// ChunkingConfigInterface can optionally be implemented by a StorageConfigInterface to have Store split values over
+// multiple chunk keys when their payload (after compression and encryption) would take more than GetMaxChunkSize()
+// bytes base64 encoded, as it is sent to Vault.  Leave some headroom below Vault's max request size for the rest of
+// the request, a few hundred bytes is plenty.  The key itself then holds a manifest referencing the version of every
+// chunk, so older versions of the key stay readable with LoadVersion.
+//
+// Chunks are removed when the key is deleted with DeleteModeMetadata, and the chunk versions referenced by the latest
+// manifest are destroyed along with it by DeleteModeDestroy (older versions of the key sharing them, after a Rollback,
+// can then no longer be read).  When a value shrinks, chunks that are no longer referenced by the latest manifest are
+// kept for the older versions referencing them.
+type ChunkingConfigInterface interface {
+	GetMaxChunkSize() int
+}
+
+func (s *Storage) maxChunkSize() int {
+	if config, ok := s.config.(ChunkingConfigInterface); ok {
+		return config.GetMaxChunkSize()
+	}
+
+	return 0
+}
+
+// needsChunks reports whether a payload of 'size' bytes has to be split over chunk keys
+func (s *Storage) needsChunks(size int) bool {
+	max := s.maxChunkSize()
+	return max > 0 && base64.StdEncoding.EncodedLen(size) > max
+}
+
+// chunkPayloadSize is the number of payload bytes that fit in a chunk of maxChunkSize() bytes once base64 encoded
+func (s *Storage) chunkPayloadSize() int {
+	size := base64.StdEncoding.DecodedLen(s.maxChunkSize())
+	if size < 1 {
+		return 1
+	}
+
+	return size
+}
+
+func chunkKey(key string, index int) string {
+	return Sprintf("%s%s/%d", key, chunksSuffix, index)
+}
+
+// isChunksDirectory reports whether a List() entry is the directory holding the chunks of a key
+func isChunksDirectory(entry string) bool {
+	return strings.HasSuffix(entry, chunksSuffix+"/")
+}
+
+// storeChunks writes the payload of secret to chunk keys if it is too large to be stored in one request, and turns
+// secret in to the manifest referencing them.  Secrets that are small enough are left untouched.
+func (s *Storage) storeChunks(ctx context.Context, key string, secret *certificateSecret) error {
+	payload := secret.Certmagic.Data
+	if !s.needsChunks(len(payload)) {
+		return nil
+	}
+
+	max := s.chunkPayloadSize()
+	versions := make([]int, 0, len(payload)/max+1)
+	for offset := 0; offset < len(payload); offset += max {
+		end := offset + max
+		if end > len(payload) {
+			end = len(payload)
+		}
+
+		chunk := &certificateSecret{
+			FormatVersion: currentFormatVersion,
+			Certmagic:     certMagicCertificateSecret{Data: payload[offset:end]},
+		}
+		result, err := s.writeSecret(ctx, chunkKey(key, len(versions)), chunk)
+		if err != nil {
+			return err
+		}
+		s.describeKey(ctx, chunkKey(key, len(versions)), nil, result.Data.Version)
+
+		versions = append(versions, result.Data.Version)
+	}
+
+	s.logger.Debugw("Stored certificate in chunks", "key", key, "chunks", len(versions), "size", len(payload))
+	secret.Certmagic.Data = nil
+	secret.Certmagic.Chunks = versions
+
+	return nil
+}
+
+// loadChunks reassembles the payload of key from the chunk versions referenced by its manifest
+func (s *Storage) loadChunks(ctx context.Context, key string, versions []int) ([]byte, error) {
+	payload := make([]byte, 0)
+	for index, version := range versions {
+		result, err := s.loadVersion(ctx, chunkKey(key, index), version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, Errorf("%w: %s: chunk %d (version %d) is missing", ErrCorrupted, key, index, version)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		payload = append(payload, result.Data.Data.Certmagic.Data...)
+	}
+
+	return payload, nil
+}
+
+// destroyChunks permanently destroys the chunk versions referenced by a manifest of key
+func (s *Storage) destroyChunks(ctx context.Context, key string, versions []int) error {
+	for index, version := range versions {
+		if err := s.destroyVersions(ctx, chunkKey(key, index), []int{version}); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteChunks permanently removes every chunk of key, if it has any
+func (s *Storage) deleteChunks(ctx context.Context, key string) error {
+	chunks, _, err := s.listDirectory(ctx, s.getToken(ctx), key+chunksSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range chunks {
+		if err := s.deleteMetadata(ctx, chunk); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
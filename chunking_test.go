@@ -0,0 +1,68 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestChunks(t *testing.T) {
+	ctx := context.Background()
+	storage, vault := newTestStorage(t, &Config{MaxChunkSize: 100, DeleteMode: DeleteModeDestroy})
+
+	key := "certificates/example.com/example.com.json"
+	value := strings.Repeat("0123456789", 100)
+	if err := storage.Store(ctx, key, []byte(value)); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := storage.Load(ctx, key)
+	if err != nil || string(loaded) != value {
+		t.Fatalf("Load(%q) = %d bytes, %v", key, len(loaded), err)
+	}
+
+	// Chunks are limited by their encoded size
+	chunks := 0
+	for ; vault.Versions("certmagic/"+chunkKey(key, chunks)) > 0; chunks++ {
+		data, _ := vault.Version("certmagic/"+chunkKey(key, chunks), 1)
+		encoded := data["certmagic"].(map[string]interface{})["data"].(string)
+		if len(encoded) > 100 {
+			t.Errorf("chunk %d is %d bytes encoded, want at most 100", chunks, len(encoded))
+		}
+	}
+	if chunks < 10 {
+		t.Fatalf("value is stored in %d chunks, want at least 10", chunks)
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	for index := 0; index < chunks; index++ {
+		if _, destroyed := vault.Version("certmagic/"+chunkKey(key, index), 1); !destroyed {
+			t.Errorf("chunk %d wasn't destroyed along with the key", index)
+		}
+	}
+}
+
+// TestChunkRetention checks that chunk keys get the retention settings of the key they belong to when they're created,
+// but aren't described in custom_metadata
+func TestChunkRetention(t *testing.T) {
+	ctx := context.Background()
+	storage, vault := newTestStorage(t, &Config{MaxChunkSize: 100, MaxVersions: 5, WriteCustomMetadata: true})
+
+	key := "certificates/example.com/example.com.json"
+	for _, value := range []string{strings.Repeat("a", 1000), strings.Repeat("b", 1000)} {
+		if err := storage.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{chunkKey(key, 0), chunkKey(key, 9)} {
+		if writes := vault.Requests("POST secret/metadata/certmagic/" + path); writes != 1 {
+			t.Errorf("metadata of %q written %d times, want once", path, writes)
+		}
+		if custom := vault.CustomMetadata("certmagic/" + path); len(custom) != 0 {
+			t.Errorf("custom_metadata of %q = %v, want none", path, custom)
+		}
+	}
+}
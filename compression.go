@@ -0,0 +1,86 @@
+package certmagic_vault_storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	. "fmt"
+	"io"
+)
+
+// ErrCompression is returned when a stored value cannot be decompressed.
+var ErrCompression = errors.New("compression error")
+
+// Compression is the algorithm used to compress values before they are stored.
+type Compression string
+
+const (
+	// CompressionNone disables compression (the default)
+	CompressionNone Compression = ""
+
+	// CompressionGzip compresses values with gzip
+	CompressionGzip Compression = "gzip"
+
+	// defaultCompressionThreshold is used when GetCompressionThreshold() isn't positive.  Smaller values hardly
+	// compress, and are not worth the CPU.
+	defaultCompressionThreshold = 4096
+)
+
+// CompressionConfigInterface can optionally be implemented by a StorageConfigInterface to have Store compress values
+// of at least GetCompressionThreshold() bytes.  Compressed records are marked as such, so Load and Stat handle them
+// whatever the current configuration is.
+type CompressionConfigInterface interface {
+	GetCompression() Compression
+	GetCompressionThreshold() int
+}
+
+// compression returns the configured algorithm and threshold, the algorithm is empty when compression is disabled
+func (s *Storage) compression() (Compression, int) {
+	config, ok := s.config.(CompressionConfigInterface)
+	if !ok {
+		return CompressionNone, 0
+	}
+
+	threshold := config.GetCompressionThreshold()
+	if threshold <= 0 {
+		threshold = defaultCompressionThreshold
+	}
+
+	return config.GetCompression(), threshold
+}
+
+func compressValue(algorithm Compression, value []byte) ([]byte, error) {
+	if algorithm != CompressionGzip {
+		return nil, Errorf("%w: unsupported algorithm %q", ErrCompression, algorithm)
+	}
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(value); err != nil {
+		return nil, Errorf("%w: %s", ErrCompression, err.Error())
+	}
+	if err := writer.Close(); err != nil {
+		return nil, Errorf("%w: %s", ErrCompression, err.Error())
+	}
+
+	return buf.Bytes(), nil
+}
+
+func decompressValue(algorithm Compression, compressed []byte) ([]byte, error) {
+	if algorithm != CompressionGzip {
+		return nil, Errorf("%w: unsupported algorithm %q", ErrCompression, algorithm)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, Errorf("%w: %s", ErrCompression, err.Error())
+	}
+	defer reader.Close()
+
+	value, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, Errorf("%w: %s", ErrCompression, err.Error())
+	}
+
+	return value, nil
+}
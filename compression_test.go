@@ -0,0 +1,48 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestCompression round trips values on both sides of the compression threshold, and checks that Stat reports the
+// size of the value rather than of what was stored
+func TestCompression(t *testing.T) {
+	ctx := context.Background()
+	storage, vault := newTestStorage(t, &Config{Compression: CompressionGzip, CompressionThreshold: 100})
+
+	tests := []struct {
+		size       int
+		compressed bool
+	}{
+		{size: 99, compressed: false},
+		{size: 100, compressed: true},
+		{size: 10000, compressed: true},
+	}
+
+	for _, test := range tests {
+		key := "certificates/example.com/" + strconv.Itoa(test.size) + ".json"
+		value := strings.Repeat("x", test.size)
+		if err := storage.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+
+		data, _ := vault.Version("certmagic/"+key, 1)
+		record := data["certmagic"].(map[string]interface{})
+		if compressed := record["compression"] == string(CompressionGzip); compressed != test.compressed {
+			t.Errorf("%d bytes stored with compression %v, want compressed %t", test.size, record["compression"], test.compressed)
+		}
+
+		loaded, err := storage.Load(ctx, key)
+		if err != nil || string(loaded) != value {
+			t.Errorf("Load(%q) = %d bytes, %v, want %d bytes", key, len(loaded), err, test.size)
+		}
+
+		info, err := storage.Stat(ctx, key)
+		if err != nil || info.Size != int64(test.size) {
+			t.Errorf("Stat(%q).Size = %d, %v, want %d", key, info.Size, err, test.size)
+		}
+	}
+}
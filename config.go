@@ -0,0 +1,436 @@
+package certmagic_vault_storage
+
+import (
+	"crypto/x509"
+	"errors"
+	. "fmt"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidConfig is returned by NewStorageE when the StorageConfigInterface can't work.
+var ErrInvalidConfig = errors.New("invalid storage config")
+
+// validateConfig returns an error listing every problem found in config, or nil if there are none
+func validateConfig(config StorageConfigInterface) error {
+	if config == nil {
+		return Errorf("%w: config is nil", ErrInvalidConfig)
+	}
+
+	var problems []error
+	if config.GetLogger() == nil {
+		problems = append(problems, errors.New("logger is required"))
+	}
+
+	if config.GetVaultBaseUrl() == "" {
+		problems = append(problems, errors.New("vault base url is required"))
+	} else if parsed, err := url.Parse(config.GetVaultBaseUrl()); err != nil {
+		problems = append(problems, Errorf("vault base url %q is invalid: %w", config.GetVaultBaseUrl(), err))
+	} else if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		problems = append(problems, Errorf("vault base url %q must use http or https", config.GetVaultBaseUrl()))
+	}
+
+	if config.GetSecretsPath() == "" {
+		problems = append(problems, errors.New("secrets path is required"))
+	}
+
+	if config.GetToken() == "" {
+		switch {
+		case config.GetApproleRoleId() == "" && config.GetApproleSecretId() == "":
+			problems = append(problems, errors.New("either a token or approle role id and secret id are required"))
+		case config.GetApproleRoleId() == "":
+			problems = append(problems, errors.New("approle role id is required with an approle secret id"))
+		case config.GetApproleSecretId() == "":
+			problems = append(problems, errors.New("approle secret id is required with an approle role id"))
+		}
+		if (config.GetApproleRoleId() != "" || config.GetApproleSecretId() != "") && config.GetApproleLoginPath() == "" {
+			problems = append(problems, errors.New("approle login path is required when using approle"))
+		}
+	}
+
+	if config.GetLockTimeout() <= 0 {
+		problems = append(problems, Errorf("lock timeout must be positive, got %s", time.Duration(config.GetLockTimeout())))
+	}
+	if config.GetLockPollingInterval() <= 0 {
+		problems = append(problems, Errorf("lock polling interval must be positive, got %s", time.Duration(config.GetLockPollingInterval())))
+	}
+
+	problems = append(problems, validateOptionalConfig(config)...)
+	if len(problems) > 0 {
+		return Errorf("%w: %w", ErrInvalidConfig, errors.Join(problems...))
+	}
+
+	return nil
+}
+
+// validateOptionalConfig checks the values of the optional configuration interfaces config implements
+func validateOptionalConfig(config StorageConfigInterface) []error {
+	var problems []error
+	if c, ok := config.(DeleteModeConfigInterface); ok {
+		switch c.GetDeleteMode() {
+		case "", DeleteModeMetadata, DeleteModeSoft, DeleteModeDestroy:
+		default:
+			problems = append(problems, Errorf("unknown delete mode %q", c.GetDeleteMode()))
+		}
+	}
+
+	if c, ok := config.(StorageFormatConfigInterface); ok {
+		switch c.GetStorageFormat() {
+		case "", StorageFormatBase64, StorageFormatText:
+		default:
+			problems = append(problems, Errorf("unknown storage format %q", c.GetStorageFormat()))
+		}
+	}
+
+	if c, ok := config.(CompressionConfigInterface); ok {
+		switch c.GetCompression() {
+		case CompressionNone, CompressionGzip:
+		default:
+			problems = append(problems, Errorf("unknown compression %q", c.GetCompression()))
+		}
+	}
+
+	if c, ok := config.(ExistsErrorPolicyConfigInterface); ok {
+		switch c.GetExistsErrorPolicy() {
+		case "", ExistsErrorReportMissing, ExistsErrorReportExists:
+		default:
+			problems = append(problems, Errorf("unknown exists error policy %q", c.GetExistsErrorPolicy()))
+		}
+	}
+
+	if c, ok := config.(ChunkingConfigInterface); ok && c.GetMaxChunkSize() < 0 {
+		problems = append(problems, Errorf("max chunk size must not be negative, got %d", c.GetMaxChunkSize()))
+	}
+
+	if c, ok := config.(TLSConfigInterface); ok && c.GetCACert() != "" {
+		if pem, err := os.ReadFile(c.GetCACert()); err != nil {
+			problems = append(problems, Errorf("ca cert is unreadable: %w", err))
+		} else if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			problems = append(problems, Errorf("ca cert %s holds no certificates", c.GetCACert()))
+		}
+	}
+
+	if c, ok := config.(TransitConfigInterface); ok && c.GetTransitMountPath() != "" && c.GetTransitKeyName() == "" {
+		problems = append(problems, errors.New("transit key name is required with a transit mount path"))
+	}
+
+	return problems
+}
+
+const (
+	defaultVaultAddr           = "https://127.0.0.1:8200"
+	defaultSecretsPath         = "secret"
+	defaultPathPrefix          = "certmagic"
+	defaultApproleLoginPath    = "auth/approle/login"
+	defaultApproleLogoutPath   = "auth/token/revoke-self"
+	defaultLockTimeout         = Duration(60 * time.Second)
+	defaultLockPollingInterval = Duration(5 * time.Second)
+)
+
+// TLSConfigInterface can optionally be implemented by a StorageConfigInterface to verify Vault's certificate against
+// the CA certificate(s) in the PEM file at GetCACert(), rather than the system's root CAs.
+type TLSConfigInterface interface {
+	GetCACert() string
+}
+
+// NamespaceConfigInterface can optionally be implemented by a StorageConfigInterface to make every request in a Vault
+// Enterprise namespace.
+type NamespaceConfigInterface interface {
+	GetNamespace() string
+}
+
+// Config is a ready-made StorageConfigInterface, implementing every optional interface of this package too.  Zero
+// values fall back to the defaults documented on each field, so it can be unmarshalled from a partial JSON or YAML
+// document, and completed from the environment with LoadEnv.
+type Config struct {
+	// URL of the Vault server, without the "/v1" API prefix (defaults to https://127.0.0.1:8200)
+	URL *URL `json:"url,omitempty" yaml:"url,omitempty"`
+
+	// Token authenticates with Vault.  When empty, Storage logs in with the AppRole credentials instead.
+	Token string `json:"token,omitempty" yaml:"token,omitempty"`
+
+	// ApproleLoginPath and ApproleLogoutPath default to "auth/approle/login" and "auth/token/revoke-self"
+	ApproleLoginPath  string `json:"approle_login_path,omitempty" yaml:"approle_login_path,omitempty"`
+	ApproleLogoutPath string `json:"approle_logout_path,omitempty" yaml:"approle_logout_path,omitempty"`
+	ApproleRoleId     string `json:"approle_role_id,omitempty" yaml:"approle_role_id,omitempty"`
+	ApproleSecretId   string `json:"approle_secret_id,omitempty" yaml:"approle_secret_id,omitempty"`
+
+	// SecretsPath is the mount path of the kv-v2 engine (defaults to "secret"), PathPrefix the path below it that
+	// CertMagic's keys are stored in (defaults to "certmagic")
+	SecretsPath string `json:"secrets_path,omitempty" yaml:"secrets_path,omitempty"`
+	PathPrefix  string `json:"path_prefix,omitempty" yaml:"path_prefix,omitempty"`
+
+	// Namespace is the Vault Enterprise namespace, CACert the path to a PEM file with the CA certificate(s) to verify
+	// Vault's certificate against
+	Namespace          string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	CACert             string `json:"ca_cert,omitempty" yaml:"ca_cert,omitempty"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
+
+	// LockTimeout and LockPollingInterval default to 60s and 5s
+	LockTimeout         Duration `json:"lock_timeout,omitempty" yaml:"lock_timeout,omitempty"`
+	LockPollingInterval Duration `json:"lock_polling_interval,omitempty" yaml:"lock_polling_interval,omitempty"`
+
+	// See KeyMetadataConfigInterface and CustomMetadataConfigInterface
+	MaxVersions         int      `json:"max_versions,omitempty" yaml:"max_versions,omitempty"`
+	DeleteVersionAfter  Duration `json:"delete_version_after,omitempty" yaml:"delete_version_after,omitempty"`
+	CasRequired         bool     `json:"cas_required,omitempty" yaml:"cas_required,omitempty"`
+	WriteCustomMetadata bool     `json:"write_custom_metadata,omitempty" yaml:"write_custom_metadata,omitempty"`
+
+	// See DeleteModeConfigInterface, ExistsErrorPolicyConfigInterface and ListConcurrencyConfigInterface
+	DeleteMode        DeleteMode        `json:"delete_mode,omitempty" yaml:"delete_mode,omitempty"`
+	ExistsErrorPolicy ExistsErrorPolicy `json:"exists_error_policy,omitempty" yaml:"exists_error_policy,omitempty"`
+	ListConcurrency   int               `json:"list_concurrency,omitempty" yaml:"list_concurrency,omitempty"`
+
+	// See StorageFormatConfigInterface, CompressionConfigInterface and ChunkingConfigInterface
+	StorageFormat        StorageFormat `json:"storage_format,omitempty" yaml:"storage_format,omitempty"`
+	Compression          Compression   `json:"compression,omitempty" yaml:"compression,omitempty"`
+	CompressionThreshold int           `json:"compression_threshold,omitempty" yaml:"compression_threshold,omitempty"`
+	MaxChunkSize         int           `json:"max_chunk_size,omitempty" yaml:"max_chunk_size,omitempty"`
+
+	// See TransitConfigInterface
+	TransitMountPath string `json:"transit_mount_path,omitempty" yaml:"transit_mount_path,omitempty"`
+	TransitKeyName   string `json:"transit_key_name,omitempty" yaml:"transit_key_name,omitempty"`
+
+	// See AuditConfigInterface and UnsafeDebugConfigInterface
+	InstanceID  string `json:"instance_id,omitempty" yaml:"instance_id,omitempty"`
+	UnsafeDebug bool   `json:"unsafe_debug,omitempty" yaml:"unsafe_debug,omitempty"`
+
+	// Logger defaults to zap's global logger.  It and the hooks below can't be unmarshalled, set them in code.
+	Logger                *zap.SugaredLogger   `json:"-" yaml:"-"`
+	EncryptionKeyProvider KeyProvider          `json:"-" yaml:"-"`
+	MetricsCollector      MetricsCollector     `json:"-" yaml:"-"`
+	TracerProvider        trace.TracerProvider `json:"-" yaml:"-"`
+	AuditSink             AuditSink            `json:"-" yaml:"-"`
+}
+
+var (
+	_ StorageConfigInterface           = (*Config)(nil)
+	_ TLSConfigInterface               = (*Config)(nil)
+	_ NamespaceConfigInterface         = (*Config)(nil)
+	_ KeyMetadataConfigInterface       = (*Config)(nil)
+	_ CustomMetadataConfigInterface    = (*Config)(nil)
+	_ DeleteModeConfigInterface        = (*Config)(nil)
+	_ ExistsErrorPolicyConfigInterface = (*Config)(nil)
+	_ ListConcurrencyConfigInterface   = (*Config)(nil)
+	_ StorageFormatConfigInterface     = (*Config)(nil)
+	_ CompressionConfigInterface       = (*Config)(nil)
+	_ ChunkingConfigInterface          = (*Config)(nil)
+	_ EncryptionConfigInterface        = (*Config)(nil)
+	_ TransitConfigInterface           = (*Config)(nil)
+	_ UnsafeDebugConfigInterface       = (*Config)(nil)
+	_ MetricsConfigInterface           = (*Config)(nil)
+	_ TracingConfigInterface           = (*Config)(nil)
+	_ AuditConfigInterface             = (*Config)(nil)
+)
+
+// ConfigFromEnv returns a Config with its defaults, completed from the environment (see LoadEnv)
+func ConfigFromEnv() (*Config, error) {
+	config := &Config{}
+	if err := config.LoadEnv(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// LoadEnv overrides the Config with the standard Vault environment variables that are set: VAULT_ADDR, VAULT_TOKEN,
+// VAULT_CACERT, VAULT_NAMESPACE and VAULT_SKIP_VERIFY.
+func (c *Config) LoadEnv() error {
+	if addr := os.Getenv("VAULT_ADDR"); addr != "" {
+		parsed, err := ParseURL(addr)
+		if err != nil {
+			return Errorf("%w: VAULT_ADDR: %w", ErrInvalidConfig, err)
+		}
+		c.URL = parsed
+	}
+
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		c.Token = token
+	}
+
+	if caCert := os.Getenv("VAULT_CACERT"); caCert != "" {
+		c.CACert = caCert
+	}
+
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		c.Namespace = namespace
+	}
+
+	if skipVerify := os.Getenv("VAULT_SKIP_VERIFY"); skipVerify != "" {
+		parsed, err := strconv.ParseBool(skipVerify)
+		if err != nil {
+			return Errorf("%w: VAULT_SKIP_VERIFY: %w", ErrInvalidConfig, err)
+		}
+		c.InsecureSkipVerify = parsed
+	}
+
+	return nil
+}
+
+func (c *Config) GetLogger() *zap.SugaredLogger {
+	if c.Logger == nil {
+		return zap.S()
+	}
+
+	return c.Logger
+}
+
+// GetVaultBaseUrl returns URL with the "/v1" API prefix appended
+func (c *Config) GetVaultBaseUrl() string {
+	addr := defaultVaultAddr
+	if c.URL != nil && c.URL.URL != nil {
+		addr = c.URL.String()
+	}
+
+	addr = strings.TrimRight(addr, "/")
+	if strings.HasSuffix(addr, "/v1") {
+		return addr
+	}
+
+	return addr + "/v1"
+}
+
+func (c *Config) GetToken() string {
+	return c.Token
+}
+
+func (c *Config) GetApproleLoginPath() string {
+	return stringOrDefault(c.ApproleLoginPath, defaultApproleLoginPath)
+}
+
+func (c *Config) GetApproleLogoutPath() string {
+	return stringOrDefault(c.ApproleLogoutPath, defaultApproleLogoutPath)
+}
+
+func (c *Config) GetApproleRoleId() string {
+	return c.ApproleRoleId
+}
+
+func (c *Config) GetApproleSecretId() string {
+	return c.ApproleSecretId
+}
+
+func (c *Config) GetSecretsPath() string {
+	return stringOrDefault(strings.Trim(c.SecretsPath, "/"), defaultSecretsPath)
+}
+
+func (c *Config) GetPathPrefix() string {
+	return stringOrDefault(strings.Trim(c.PathPrefix, "/"), defaultPathPrefix)
+}
+
+func (c *Config) GetInsecureSkipVerify() bool {
+	return c.InsecureSkipVerify
+}
+
+func (c *Config) GetCACert() string {
+	return c.CACert
+}
+
+func (c *Config) GetNamespace() string {
+	return c.Namespace
+}
+
+func (c *Config) GetLockTimeout() Duration {
+	if c.LockTimeout <= 0 {
+		return defaultLockTimeout
+	}
+
+	return c.LockTimeout
+}
+
+func (c *Config) GetLockPollingInterval() Duration {
+	if c.LockPollingInterval <= 0 {
+		return defaultLockPollingInterval
+	}
+
+	return c.LockPollingInterval
+}
+
+func (c *Config) GetMaxVersions() int {
+	return c.MaxVersions
+}
+
+func (c *Config) GetDeleteVersionAfter() Duration {
+	return c.DeleteVersionAfter
+}
+
+func (c *Config) GetCasRequired() bool {
+	return c.CasRequired
+}
+
+func (c *Config) GetWriteCustomMetadata() bool {
+	return c.WriteCustomMetadata
+}
+
+func (c *Config) GetDeleteMode() DeleteMode {
+	return c.DeleteMode
+}
+
+func (c *Config) GetExistsErrorPolicy() ExistsErrorPolicy {
+	return c.ExistsErrorPolicy
+}
+
+func (c *Config) GetListConcurrency() int {
+	return c.ListConcurrency
+}
+
+func (c *Config) GetStorageFormat() StorageFormat {
+	return c.StorageFormat
+}
+
+func (c *Config) GetCompression() Compression {
+	return c.Compression
+}
+
+func (c *Config) GetCompressionThreshold() int {
+	return c.CompressionThreshold
+}
+
+func (c *Config) GetMaxChunkSize() int {
+	return c.MaxChunkSize
+}
+
+func (c *Config) GetEncryptionKeyProvider() KeyProvider {
+	return c.EncryptionKeyProvider
+}
+
+func (c *Config) GetTransitMountPath() string {
+	return c.TransitMountPath
+}
+
+func (c *Config) GetTransitKeyName() string {
+	return c.TransitKeyName
+}
+
+func (c *Config) GetUnsafeDebug() bool {
+	return c.UnsafeDebug
+}
+
+func (c *Config) GetMetricsCollector() MetricsCollector {
+	return c.MetricsCollector
+}
+
+func (c *Config) GetTracerProvider() trace.TracerProvider {
+	return c.TracerProvider
+}
+
+func (c *Config) GetAuditSink() AuditSink {
+	return c.AuditSink
+}
+
+func (c *Config) GetInstanceID() string {
+	return c.InstanceID
+}
+
+func stringOrDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,144 @@
+package certmagic_vault_storage
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestNewStorageE checks that every problem with a config is reported at once
+func TestNewStorageE(t *testing.T) {
+	tests := []struct {
+		name   string
+		config StorageConfigInterface
+		want   []string
+	}{
+		{
+			name:   "valid",
+			config: &Config{Token: "token"},
+		},
+		{
+			name:   "nil",
+			config: nil,
+			want:   []string{"config is nil"},
+		},
+		{
+			name:   "no credentials",
+			config: &Config{URL: MustParseURL("ftp://vault.example.com"), DeleteMode: "shred", Compression: "lz4"},
+			want: []string{
+				"either a token or approle role id and secret id are required",
+				"must use http or https",
+				`unknown delete mode "shred"`,
+				`unknown compression "lz4"`,
+			},
+		},
+		{
+			name:   "incomplete approle",
+			config: &Config{ApproleSecretId: "secret", MaxChunkSize: -1, TransitMountPath: "transit"},
+			want: []string{
+				"approle role id is required with an approle secret id",
+				"max chunk size must not be negative, got -1",
+				"transit key name is required with a transit mount path",
+			},
+		},
+		{
+			name: "unknown values",
+			config: &Config{
+				Token:             "token",
+				StorageFormat:     "yaml",
+				ExistsErrorPolicy: "guess",
+				CACert:            filepath.Join(t.TempDir(), "missing.pem"),
+			},
+			want: []string{
+				`unknown storage format "yaml"`,
+				`unknown exists error policy "guess"`,
+				"ca cert is unreadable",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, err := NewStorageE(test.config)
+			if len(test.want) == 0 {
+				if err != nil || storage == nil {
+					t.Fatalf("NewStorageE() = %v, %v", storage, err)
+				}
+				return
+			}
+
+			if storage != nil || !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("NewStorageE() = %v, %v, want ErrInvalidConfig", storage, err)
+			}
+			for _, problem := range test.want {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("NewStorageE() error %q doesn't report %q", err.Error(), problem)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("VAULT_ADDR", "https://vault.example.com:8200")
+	t.Setenv("VAULT_TOKEN", "hvs.token")
+	t.Setenv("VAULT_CACERT", "/etc/vault/ca.pem")
+	t.Setenv("VAULT_NAMESPACE", "certs")
+	t.Setenv("VAULT_SKIP_VERIFY", "true")
+
+	config := &Config{Token: "overridden", SecretsPath: "kv"}
+	if err := config.LoadEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := config.GetVaultBaseUrl(); got != "https://vault.example.com:8200/v1" {
+		t.Errorf("GetVaultBaseUrl() = %q", got)
+	}
+	if config.Token != "hvs.token" || config.CACert != "/etc/vault/ca.pem" || config.Namespace != "certs" || !config.InsecureSkipVerify {
+		t.Errorf("LoadEnv() = %+v", config)
+	}
+	if config.SecretsPath != "kv" {
+		t.Errorf("LoadEnv() changed SecretsPath to %q", config.SecretsPath)
+	}
+
+	// Variables that aren't set leave the config alone
+	t.Setenv("VAULT_TOKEN", "")
+	if err := config.LoadEnv(); err != nil || config.Token != "hvs.token" {
+		t.Errorf("LoadEnv() without VAULT_TOKEN = %v, token %q", err, config.Token)
+	}
+
+	for name, value := range map[string]string{"VAULT_SKIP_VERIFY": "maybe", "VAULT_ADDR": "https://vault example.com"} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if err := (&Config{}).LoadEnv(); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("LoadEnv() with %s=%q = %v, want ErrInvalidConfig", name, value, err)
+			}
+		})
+	}
+}
+
+func TestGetVaultBaseUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "", want: "https://127.0.0.1:8200/v1"},
+		{url: "http://vault:8200", want: "http://vault:8200/v1"},
+		{url: "http://vault:8200/", want: "http://vault:8200/v1"},
+		{url: "http://vault:8200/v1", want: "http://vault:8200/v1"},
+		{url: "http://vault:8200/v1/", want: "http://vault:8200/v1"},
+		{url: "https://example.com/vault", want: "https://example.com/vault/v1"},
+	}
+
+	for _, test := range tests {
+		config := &Config{}
+		if test.url != "" {
+			config.URL = MustParseURL(test.url)
+		}
+
+		if got := config.GetVaultBaseUrl(); got != test.want {
+			t.Errorf("GetVaultBaseUrl() with URL %q = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
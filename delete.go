@@ -0,0 +1,223 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	. "fmt"
+	"io/fs"
+	"net/http"
+)
+
+// DeleteMode selects how Storage.Delete removes a key from Vault's kv-v2 engine.
+type DeleteMode string
+
+const (
+	// DeleteModeMetadata deletes the key's metadata, permanently removing every version (the default).
+	DeleteModeMetadata DeleteMode = "metadata"
+
+	// DeleteModeSoft soft-deletes the latest version through the data endpoint.  It can be recovered with Undelete.
+	DeleteModeSoft DeleteMode = "soft"
+
+	// DeleteModeDestroy permanently destroys the latest version (and the chunks it references), but keeps older versions
+	// and the key's metadata.
+	DeleteModeDestroy DeleteMode = "destroy"
+)
+
+// DeleteModeConfigInterface can optionally be implemented by a StorageConfigInterface to change how Delete behaves.
+//
+// Note that Vault keeps listing soft-deleted and destroyed keys until their metadata is removed, so List may return
+// keys that Load reports as not existing when anything other than DeleteModeMetadata is used.
+type DeleteModeConfigInterface interface {
+	GetDeleteMode() DeleteMode
+}
+
+func (s *Storage) deleteMode() DeleteMode {
+	if config, ok := s.config.(DeleteModeConfigInterface); ok && config.GetDeleteMode() != "" {
+		return config.GetDeleteMode()
+	}
+
+	return DeleteModeMetadata
+}
+
+// Undelete restores the latest version of key after it was removed with DeleteModeSoft.  It is a noop if the latest
+// version is not deleted, and returns fs.ErrNotExist if it has been destroyed.
+func (s *Storage) Undelete(ctx context.Context, key string) (err error) {
+	ctx, end := s.startOperation(ctx, OperationUndelete, key)
+	defer end(&err)
+
+	version := 0
+	defer s.audit(ctx, OperationUndelete, key, &version, &err)
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	meta, err := s.readMetadata(ctx, key)
+	if err != nil {
+		return err
+	}
+	version = meta.Data.CurrentVersion
+
+	current, ok := meta.Data.Versions[Sprintf("%d", meta.Data.CurrentVersion)]
+	if !ok || current.Destroyed {
+		return fs.ErrNotExist
+	}
+
+	if !current.deleted() {
+		return nil
+	}
+
+	s.logger.Infow("Undeleting certificate", "key", key, "version", meta.Data.CurrentVersion)
+
+	body := &versionsInput{Versions: []int{meta.Data.CurrentVersion}}
+	result := &response{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.PostRaw(ctx, s.getToken(ctx), s.vaultUndeletePath(key), body, result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to undelete certificate",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultUndeletePath(key)),
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultUndeletePath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to undelete certificate",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultUndeletePath(key)),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultUndeletePath(key), resp, errResponse, nil)
+	}
+
+	return nil
+}
+
+// DestroyVersions permanently removes the given versions of key.  Other versions and the key's metadata are kept.
+func (s *Storage) DestroyVersions(ctx context.Context, key string, versions ...int) (err error) {
+	ctx, end := s.startOperation(ctx, OperationDestroyVersions, key)
+	defer end(&err)
+
+	defer func() {
+		for _, version := range versions {
+			version := version
+			s.audit(ctx, OperationDestroyVersions, key, &version, &err)
+		}
+	}()
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	return s.destroyVersions(ctx, key, versions)
+}
+
+func (s *Storage) destroyVersions(ctx context.Context, key string, versions []int) error {
+	s.logger.Debugw("destroyVersions() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDestroyPath(key)), "versions", versions)
+
+	body := &versionsInput{Versions: versions}
+	result := &response{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.PutRaw(ctx, s.getToken(ctx), s.vaultDestroyPath(key), body, result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to destroy certificate versions",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDestroyPath(key)),
+			"versions", versions,
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultDestroyPath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
+		return newVaultError(s.vaultDestroyPath(key), resp, errResponse, nil)
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to destroy certificate versions",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDestroyPath(key)),
+			"versions", versions,
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultDestroyPath(key), resp, errResponse, nil)
+	}
+
+	return nil
+}
+
+// destroyLatest implements DeleteModeDestroy, destroying the chunks referenced by the latest version too.  It returns
+// the version that was destroyed.
+func (s *Storage) destroyLatest(ctx context.Context, key string) (int, error) {
+	meta, err := s.readMetadata(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	version := meta.Data.CurrentVersion
+	if current, ok := meta.Data.Versions[Sprintf("%d", version)]; !ok || current.Destroyed {
+		return 0, fs.ErrNotExist
+	}
+
+	// A soft-deleted version can't be read, nor can its chunks be found
+	var chunks []int
+	if result, err := s.loadVersion(ctx, key, version); err == nil {
+		chunks = result.Data.Data.Certmagic.Chunks
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return version, err
+	}
+
+	if err := s.destroyVersions(ctx, key, []int{version}); err != nil {
+		return version, err
+	}
+
+	return version, s.destroyChunks(ctx, key, chunks)
+}
+
+// softDelete implements DeleteModeSoft
+func (s *Storage) softDelete(ctx context.Context, key string) error {
+	s.logger.Debugw("softDelete() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)))
+
+	result := &response{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.Delete(ctx, s.getToken(ctx), s.vaultDataPath(key), result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to soft-delete certificate",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultDataPath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
+		return newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to soft-delete certificate",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"testing"
+)
+
+// TestUndelete round trips a key through a soft Delete and Undelete
+func TestUndelete(t *testing.T) {
+	ctx := context.Background()
+	storage, vault := newTestStorage(t, &Config{DeleteMode: DeleteModeSoft})
+
+	key := "certificates/example.com/example.com.crt"
+	if err := storage.Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undeleting a key that isn't deleted doesn't ask Vault to
+	if err := storage.Undelete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if undeletes := vault.Requests("POST secret/undelete/certmagic/" + key); undeletes != 0 {
+		t.Errorf("Undelete() of a live key posted %d undeletes, want 0", undeletes)
+	}
+
+	if err := storage.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Load() after a soft Delete = %v, want fs.ErrNotExist", err)
+	}
+
+	if err := storage.Undelete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if undeletes := vault.Requests("POST secret/undelete/certmagic/" + key); undeletes != 1 {
+		t.Errorf("Undelete() of a deleted key posted %d undeletes, want 1", undeletes)
+	}
+
+	value, err := storage.Load(ctx, key)
+	if err != nil || string(value) != "certificate" {
+		t.Errorf("Load() after Undelete = %q, %v", value, err)
+	}
+}
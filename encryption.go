@@ -0,0 +1,184 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	. "fmt"
+	"io"
+)
+
+// ErrEncryption is returned when a value cannot be encrypted or decrypted, e.g. because a record is encrypted with a
+// key the configured KeyProvider doesn't know about.
+var ErrEncryption = errors.New("encryption error")
+
+const (
+	// encryptionSchemeAESGCM is client-side envelope encryption: the value is encrypted with a random AES-256-GCM data
+	// key, which is stored next to it wrapped by the KeyProvider.
+	encryptionSchemeAESGCM = "aes-256-gcm"
+
+	dataKeySize = 32
+)
+
+// KeyProvider wraps and unwraps the per-record data keys used for envelope encryption of stored values.  Implementations
+// can keep their keys locally (see StaticKeyProvider) or delegate to a KMS.
+type KeyProvider interface {
+	// WrapKey encrypts dataKey with the provider's current key, and returns the ID of that key along with the result.
+	WrapKey(ctx context.Context, dataKey []byte) (keyID string, wrapped []byte, err error)
+
+	// UnwrapKey decrypts a data key that was wrapped with the key identified by keyID.
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// EncryptionConfigInterface can optionally be implemented by a StorageConfigInterface to encrypt values before they
+// are sent to Vault.  Records are always decrypted with the key they were written with, so keys can be rotated by
+// changing the provider's current key, as long as old keys stay available to UnwrapKey.
+type EncryptionConfigInterface interface {
+	GetEncryptionKeyProvider() KeyProvider
+}
+
+func (s *Storage) keyProvider() KeyProvider {
+	if config, ok := s.config.(EncryptionConfigInterface); ok {
+		return config.GetEncryptionKeyProvider()
+	}
+
+	return nil
+}
+
+// NewStaticKeyProvider returns a KeyProvider using locally supplied AES keys (16, 24 or 32 bytes), indexed by key ID.
+// New data keys are wrapped with currentKeyID.
+func NewStaticKeyProvider(currentKeyID string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, Errorf("%w: unknown current key %q", ErrEncryption, currentKeyID)
+	}
+
+	for id, key := range keys {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, Errorf("%w: key %q: %s", ErrEncryption, id, err.Error())
+		}
+	}
+
+	return &StaticKeyProvider{currentKeyID: currentKeyID, keys: keys}, nil
+}
+
+// StaticKeyProvider is a KeyProvider wrapping data keys with AES-GCM using locally supplied keys.
+type StaticKeyProvider struct {
+	currentKeyID string
+	keys         map[string][]byte
+}
+
+func (p *StaticKeyProvider) WrapKey(_ context.Context, dataKey []byte) (string, []byte, error) {
+	wrapped, err := sealAESGCM(p.keys[p.currentKeyID], dataKey, []byte(p.currentKeyID))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return p.currentKeyID, wrapped, nil
+}
+
+func (p *StaticKeyProvider) UnwrapKey(_ context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, Errorf("%w: unknown key %q", ErrEncryption, keyID)
+	}
+
+	return openAESGCM(key, wrapped, []byte(keyID))
+}
+
+// encryptValue envelope encrypts value for key, returning the ciphertext and the envelope needed to decrypt it
+func (s *Storage) encryptValue(ctx context.Context, provider KeyProvider, key string, value []byte) ([]byte, *encryptionEnvelope, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, Errorf("%w: %s", ErrEncryption, err.Error())
+	}
+
+	keyID, wrapped, err := provider.WrapKey(ctx, dataKey)
+	if err != nil {
+		return nil, nil, Errorf("%w: wrapping data key: %s", ErrEncryption, err.Error())
+	}
+
+	// The key is authenticated along with the value, so records can't be swapped between keys.  It is authenticated as
+	// stored, since keys are case-folded and may be read back through a differently cased key (e.g. one from List).
+	ciphertext, err := sealAESGCM(dataKey, value, []byte(storedKey(key)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ciphertext, &encryptionEnvelope{Scheme: encryptionSchemeAESGCM, KeyID: keyID, WrappedKey: wrapped}, nil
+}
+
+// decryptValue is the inverse of encryptValue (and transitEncrypt)
+func (s *Storage) decryptValue(ctx context.Context, key string, ciphertext []byte, envelope *encryptionEnvelope) ([]byte, error) {
+	switch envelope.Scheme {
+	case encryptionSchemeAESGCM:
+		return s.decryptEnvelope(ctx, key, ciphertext, envelope)
+	case encryptionSchemeTransit:
+		return s.transitDecrypt(ctx, ciphertext, envelope)
+	}
+
+	return nil, Errorf("%w: unsupported scheme %q", ErrEncryption, envelope.Scheme)
+}
+
+func (s *Storage) decryptEnvelope(ctx context.Context, key string, ciphertext []byte, envelope *encryptionEnvelope) ([]byte, error) {
+	provider := s.keyProvider()
+	if provider == nil {
+		return nil, Errorf("%w: value is encrypted, but no key provider is configured", ErrEncryption)
+	}
+
+	dataKey, err := provider.UnwrapKey(ctx, envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		return nil, Errorf("%w: unwrapping data key: %s", ErrEncryption, err.Error())
+	}
+
+	return openAESGCM(dataKey, ciphertext, []byte(storedKey(key)))
+}
+
+// sealAESGCM encrypts plaintext, returning the random nonce followed by the ciphertext
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, Errorf("%w: %s", ErrEncryption, err.Error())
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM is the inverse of sealAESGCM
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAESGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, Errorf("%w: ciphertext too short", ErrEncryption)
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+	if err != nil {
+		return nil, Errorf("%w: %s", ErrEncryption, err.Error())
+	}
+
+	return plaintext, nil
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, Errorf("%w: %s", ErrEncryption, err.Error())
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, Errorf("%w: %s", ErrEncryption, err.Error())
+	}
+
+	return aead, nil
+}
@@ -0,0 +1,101 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestEncryptionCaseFolding loads encrypted records through the case-folded keys List returns
+func TestEncryptionCaseFolding(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, _ := newTestStorage(t, &Config{EncryptionKeyProvider: provider})
+
+	key := "certificates/*.Example.com/*.Example.com.key"
+	if err := storage.Store(ctx, key, []byte("private key")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, loadKey := range []string{key, "certificates/*.example.com/*.example.com.key"} {
+		value, err := storage.Load(ctx, loadKey)
+		if err != nil || string(value) != "private key" {
+			t.Errorf("Load(%q) = %q, %v", loadKey, value, err)
+		}
+	}
+
+	keys, err := storage.List(ctx, "certificates", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, listed := range keys {
+		if info, err := storage.Stat(ctx, listed); err != nil || !info.IsTerminal {
+			continue
+		}
+		if _, err := storage.Load(ctx, listed); err != nil {
+			t.Errorf("Load(%q) of a listed key = %v", listed, err)
+		}
+	}
+}
+
+// TestExistsWithoutDecrypting checks that ExistsE doesn't need to be able to decrypt a record
+func TestExistsWithoutDecrypting(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{EncryptionKeyProvider: provider, MaxChunkSize: 64}
+	storage, _ := newTestStorage(t, config)
+
+	keys := map[string]string{"small.key": "private key", "large.json": string(make([]byte, 256))}
+	for key, value := range keys {
+		if err := storage.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config.EncryptionKeyProvider = nil
+	for key := range keys {
+		if exists, err := storage.ExistsE(ctx, key); err != nil || !exists {
+			t.Errorf("ExistsE(%q) without a key provider = %t, %v", key, exists, err)
+		}
+	}
+	if exists, err := storage.ExistsE(ctx, "missing.key"); err != nil || exists {
+		t.Errorf("ExistsE(%q) = %t, %v", "missing.key", exists, err)
+	}
+}
+
+// TestEncryptedChecksum checks that encrypted records don't carry the digest of their plaintext
+func TestEncryptedChecksum(t *testing.T) {
+	ctx := context.Background()
+
+	provider, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": make([]byte, 32)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage, vault := newTestStorage(t, &Config{EncryptionKeyProvider: provider})
+
+	key := "certificates/example.com/example.com.key"
+	value := []byte("private key")
+	if err := storage.Store(ctx, key, value); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := vault.Version("certmagic/"+key, 1)
+	record := data["certmagic"].(map[string]interface{})
+	if record["sha256"] == checksum(value) {
+		t.Errorf("sha256 of the plaintext is stored next to its ciphertext")
+	}
+
+	// Tampering is caught before decrypting
+	record["data"] = "dGFtcGVyZWQ="
+	if _, err := storage.Load(ctx, key); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Load() of a tampered record = %v, want ErrCorrupted", err)
+	}
+}
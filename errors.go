@@ -0,0 +1,105 @@
+package certmagic_vault_storage
+
+import (
+	"errors"
+	. "fmt"
+	"gopkg.in/resty.v1"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// Sentinel errors matched (with errors.Is) by the *VaultError returned from Storage methods.  A missing key matches
+// fs.ErrNotExist, as CertMagic expects.
+var (
+	ErrVaultUnreachable = errors.New("vault is unreachable")
+	ErrBadRequest       = errors.New("bad request")
+	ErrCheckAndSet      = errors.New("check-and-set version mismatch")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrVaultSealed      = errors.New("vault is sealed")
+	ErrVaultUnavailable = errors.New("vault is unavailable")
+	ErrVaultServerError = errors.New("vault server error")
+
+	// ErrLockHeld is returned by Lock when ctx is done before the lock held by someone else is released or expires.
+	ErrLockHeld = errors.New("lock is held")
+)
+
+// VaultError describes a failed request to Vault.  StatusCode is 0 when no response was received, in which case Err
+// holds the underlying error.
+type VaultError struct {
+	StatusCode int
+	Method     string
+	Path       string
+	Errors     []string
+	Err        error
+}
+
+// newVaultError builds the error returned for a failed request to path.  It always returns a non-nil error, even when
+// Vault didn't include any error messages in the response.
+func newVaultError(path string, resp *resty.Response, errResponse *errorResponse, err error) error {
+	vaultErr := &VaultError{Path: path, Err: err}
+	if resp != nil && resp.Request != nil {
+		vaultErr.Method = resp.Request.Method
+	}
+	if resp != nil && resp.RawResponse != nil {
+		vaultErr.StatusCode = resp.StatusCode()
+	}
+	if errResponse != nil {
+		vaultErr.Errors = errResponse.Errors
+	}
+
+	return vaultErr
+}
+
+func (e *VaultError) Error() string {
+	if e.StatusCode == 0 {
+		return Sprintf("vault request to %s failed: %v", e.Path, e.Err)
+	}
+
+	if len(e.Errors) == 0 {
+		return Sprintf("vault request to %s failed with %d %s", e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return Sprintf("vault request to %s failed with %d: %s", e.Path, e.StatusCode, strings.Join(e.Errors, "; "))
+}
+
+func (e *VaultError) Unwrap() error {
+	return e.Err
+}
+
+// Is maps the response to the sentinel errors above
+func (e *VaultError) Is(target error) bool {
+	switch target {
+	case ErrVaultUnreachable:
+		return e.StatusCode == 0
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrCheckAndSet:
+		return e.StatusCode == http.StatusBadRequest && e.mentions("check-and-set")
+	case ErrPermissionDenied:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case fs.ErrNotExist:
+		return e.StatusCode == http.StatusNotFound
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrVaultSealed:
+		return e.StatusCode == http.StatusServiceUnavailable && e.mentions("sealed")
+	case ErrVaultUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	case ErrVaultServerError:
+		return e.StatusCode >= http.StatusInternalServerError
+	}
+
+	return false
+}
+
+func (e *VaultError) mentions(substr string) bool {
+	for _, message := range e.Errors {
+		if strings.Contains(strings.ToLower(message), substr) {
+			return true
+		}
+	}
+
+	return false
+}
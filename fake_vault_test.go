@@ -0,0 +1,428 @@
+package certmagic_vault_storage
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	. "fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVault is an in-memory stand-in for the parts of Vault's HTTP API that Storage uses: a kv-v2 engine mounted at
+// "secret", a Transit engine mounted at "transit" and the sys/auth endpoints checked by Health.  It is only as
+// faithful as the tests need it to be.
+type fakeVault struct {
+	mu   sync.Mutex
+	keys map[string]*fakeKey
+
+	// transitVersion is the latest version of every Transit key, RotateTransit increments it
+	transitVersion int
+
+	// requests records "<method> <path>" for every request made
+	requests []string
+
+	// capabilities overrides the capabilities reported by sys/capabilities-self for the paths it has, all of them are
+	// granted on other paths
+	capabilities map[string][]string
+
+	// deny, when set, answers kv-v2 requests it returns true for with "permission denied".  'exists' tells whether the
+	// key has any versions.
+	deny func(method, kind, path string, exists bool) bool
+}
+
+type fakeKey struct {
+	versions       []*fakeVersion
+	customMetadata map[string]string
+
+	// deleteVersionAfter gives new versions a deletion_time that far in the future, like Vault does
+	deleteVersionAfter time.Duration
+}
+
+type fakeVersion struct {
+	data      map[string]interface{}
+	created   time.Time
+	deletion  time.Time
+	destroyed bool
+}
+
+// deleted reports whether the version has been (soft) deleted, which it is from its deletion_time on
+func (v *fakeVersion) deleted() bool {
+	return !v.deletion.IsZero() && !v.deletion.After(time.Now())
+}
+
+// deletionTime formats the deletion_time of the version like Vault does, an empty string when it has none
+func (v *fakeVersion) deletionTime() string {
+	if v.deletion.IsZero() {
+		return ""
+	}
+
+	return v.deletion.Format(time.RFC3339Nano)
+}
+
+// newTestStorage returns a Storage talking to a new fakeVault, with the Vault URL, token and (unless set) logger of
+// config filled in
+func newTestStorage(t *testing.T, config *Config) (*Storage, *fakeVault) {
+	t.Helper()
+
+	vault := &fakeVault{keys: map[string]*fakeKey{}, transitVersion: 1}
+	server := httptest.NewServer(vault)
+	t.Cleanup(server.Close)
+
+	serverUrl, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config.URL = &URL{URL: serverUrl}
+	config.Token = "test-token"
+	if config.Logger == nil {
+		config.Logger = zap.NewNop().Sugar()
+	}
+
+	return NewStorage(config), vault
+}
+
+// RotateTransit makes a new latest version of the Transit keys
+func (f *fakeVault) RotateTransit() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.transitVersion++
+}
+
+// Versions returns the number of versions written to the kv-v2 key at 'path' (relative to the mount)
+func (f *fakeVault) Versions(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key := f.keys[path]; key != nil {
+		return len(key.versions)
+	}
+
+	return 0
+}
+
+// Version returns the data of 'version' of the kv-v2 key at 'path' (relative to the mount), and whether it has been
+// destroyed
+func (f *fakeVault) Version(path string, version int) (map[string]interface{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := f.keys[path]
+	if key == nil || version < 1 || version > len(key.versions) {
+		return nil, false
+	}
+
+	return key.versions[version-1].data, key.versions[version-1].destroyed
+}
+
+// Write adds a version with 'data' to the kv-v2 key at 'path' (relative to the mount), like another client would
+func (f *fakeVault) Write(path string, data map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := f.keys[path]
+	if key == nil {
+		key = &fakeKey{}
+		f.keys[path] = key
+	}
+	key.versions = append(key.versions, &fakeVersion{data: data, created: time.Now()})
+}
+
+// CustomMetadata returns the custom_metadata of the kv-v2 key at 'path' (relative to the mount)
+func (f *fakeVault) CustomMetadata(path string) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if key := f.keys[path]; key != nil {
+		return key.customMetadata
+	}
+
+	return nil
+}
+
+// Requests returns the number of requests made for "<method> <path>"
+func (f *fakeVault) Requests(request string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := 0
+	for _, made := range f.requests {
+		if made == request {
+			count++
+		}
+	}
+
+	return count
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	f.requests = append(f.requests, r.Method+" "+path)
+
+	body := map[string]interface{}{}
+	if r.ContentLength != 0 {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+
+	switch {
+	case path == "sys/health":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"initialized": true, "sealed": false, "version": "1.15.0"})
+	case path == "auth/token/lookup-self":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"ttl": 3600, "policies": []string{"default"}}})
+	case path == "sys/capabilities-self":
+		capabilities := map[string]interface{}{}
+		paths, _ := body["paths"].([]interface{})
+		for _, p := range paths {
+			capabilities[p.(string)] = []string{"create", "read", "update", "delete", "list"}
+			if granted, ok := f.capabilities[p.(string)]; ok {
+				capabilities[p.(string)] = granted
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": capabilities})
+	case strings.HasPrefix(path, "transit/"):
+		f.serveTransit(w, strings.Split(path, "/")[1], body)
+	case strings.HasPrefix(path, "secret/"):
+		parts := strings.SplitN(strings.TrimPrefix(path, "secret/"), "/", 2)
+		if len(parts) != 2 {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+		f.serveKV(w, r, parts[0], parts[1], body)
+	default:
+		writeErrors(w, http.StatusNotFound)
+	}
+}
+
+func (f *fakeVault) serveTransit(w http.ResponseWriter, operation string, body map[string]interface{}) {
+	switch operation {
+	case "encrypt":
+		plaintext, _ := base64.StdEncoding.DecodeString(body["plaintext"].(string))
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"ciphertext": f.transitEncrypt(plaintext)}})
+	case "decrypt":
+		plaintext, ok := f.transitDecrypt(body["ciphertext"].(string))
+		if !ok {
+			writeErrors(w, http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"plaintext": plaintext}})
+	case "rewrap":
+		plaintext, ok := f.transitDecrypt(body["ciphertext"].(string))
+		if !ok {
+			writeErrors(w, http.StatusBadRequest, "invalid ciphertext")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"ciphertext": f.transitEncrypt(plaintext)}})
+	default:
+		writeErrors(w, http.StatusNotFound)
+	}
+}
+
+// transitEncrypt "encrypts" with a random nonce like Vault does, so the same plaintext never gives the same ciphertext
+func (f *fakeVault) transitEncrypt(plaintext []byte) string {
+	nonce := make([]byte, 4)
+	_, _ = rand.Read(nonce)
+
+	return Sprintf("vault:v%d:%s", f.transitVersion, base64.StdEncoding.EncodeToString(append(nonce, plaintext...)))
+}
+
+func (f *fakeVault) transitDecrypt(ciphertext string) ([]byte, bool) {
+	index := strings.LastIndex(ciphertext, ":")
+	if !strings.HasPrefix(ciphertext, "vault:v") || index < 0 {
+		return nil, false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(ciphertext[index+1:])
+	if err != nil || len(decoded) < 4 {
+		return nil, false
+	}
+
+	return decoded[4:], true
+}
+
+func (f *fakeVault) serveKV(w http.ResponseWriter, r *http.Request, kind, path string, body map[string]interface{}) {
+	key := f.keys[path]
+	if f.deny != nil && f.deny(r.Method, kind, path, key != nil && len(key.versions) > 0) {
+		writeErrors(w, http.StatusForbidden, "1 error occurred:\n\t* permission denied\n\n")
+		return
+	}
+
+	switch {
+	case kind == "data" && r.Method == http.MethodGet:
+		if key == nil || len(key.versions) == 0 {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+
+		number := len(key.versions)
+		if v := r.URL.Query().Get("version"); v != "" && v != "0" {
+			number, _ = strconv.Atoi(v)
+		}
+		if number < 1 || number > len(key.versions) {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+
+		version := key.versions[number-1]
+		metadata := map[string]interface{}{
+			"version":       number,
+			"created_time":  version.created.Format(time.RFC3339Nano),
+			"deletion_time": version.deletionTime(),
+			"destroyed":     version.destroyed,
+		}
+		if version.deleted() || version.destroyed {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"data": map[string]interface{}{"data": nil, "metadata": metadata}})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"data": version.data, "metadata": metadata}})
+
+	case kind == "data" && (r.Method == http.MethodPost || r.Method == http.MethodPut):
+		if key == nil {
+			key = &fakeKey{}
+			f.keys[path] = key
+		}
+		if options, ok := body["options"].(map[string]interface{}); ok {
+			if cas, ok := options["cas"].(float64); ok && int(cas) != len(key.versions) {
+				writeErrors(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+				return
+			}
+		}
+
+		data, _ := body["data"].(map[string]interface{})
+		version := &fakeVersion{data: data, created: time.Now()}
+		if key.deleteVersionAfter > 0 {
+			version.deletion = version.created.Add(key.deleteVersionAfter)
+		}
+		key.versions = append(key.versions, version)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"version": len(key.versions), "created_time": time.Now().Format(time.RFC3339Nano)}})
+
+	case kind == "data" && r.Method == http.MethodDelete:
+		if key != nil && len(key.versions) > 0 {
+			key.versions[len(key.versions)-1].deletion = time.Now()
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case kind == "undelete" || kind == "destroy":
+		versions, _ := body["versions"].([]interface{})
+		for _, v := range versions {
+			number := int(v.(float64))
+			if key == nil || number < 1 || number > len(key.versions) {
+				continue
+			}
+			if kind == "undelete" {
+				key.versions[number-1].deletion = time.Time{}
+			} else {
+				key.versions[number-1].destroyed = true
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case kind == "metadata" && r.Method == "LIST":
+		f.serveList(w, path)
+
+	case kind == "metadata" && r.Method == http.MethodGet:
+		if key == nil {
+			writeErrors(w, http.StatusNotFound)
+			return
+		}
+
+		versions := map[string]interface{}{}
+		updated := time.Time{}
+		for i, version := range key.versions {
+			versions[strconv.Itoa(i+1)] = map[string]interface{}{
+				"created_time":  version.created.Format(time.RFC3339Nano),
+				"deletion_time": version.deletionTime(),
+				"destroyed":     version.destroyed,
+			}
+			updated = version.created
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"current_version": len(key.versions),
+			"versions":        versions,
+			"updated_time":    updated.Format(time.RFC3339Nano),
+			"custom_metadata": key.customMetadata,
+		}})
+
+	case kind == "metadata" && (r.Method == http.MethodPost || r.Method == http.MethodPut):
+		if key == nil {
+			key = &fakeKey{}
+			f.keys[path] = key
+		}
+		if custom, ok := body["custom_metadata"].(map[string]interface{}); ok {
+			key.customMetadata = map[string]string{}
+			for name, value := range custom {
+				key.customMetadata[name], _ = value.(string)
+			}
+		}
+		if after, ok := body["delete_version_after"].(string); ok {
+			key.deleteVersionAfter, _ = time.ParseDuration(after)
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case kind == "metadata" && r.Method == http.MethodDelete:
+		delete(f.keys, path)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeErrors(w, http.StatusMethodNotAllowed, "unsupported "+r.Method+" "+kind)
+	}
+}
+
+// serveList lists the entries directly below 'path' like Vault does, with a trailing slash on "directories"
+func (f *fakeVault) serveList(w http.ResponseWriter, path string) {
+	prefix := path
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	entries := map[string]bool{}
+	for name := range f.keys {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		rest := name[len(prefix):]
+		if index := strings.Index(rest, "/"); index >= 0 {
+			rest = rest[:index+1]
+		}
+		entries[rest] = true
+	}
+	if len(entries) == 0 {
+		writeErrors(w, http.StatusNotFound)
+		return
+	}
+
+	keys := make([]string, 0, len(entries))
+	for entry := range entries {
+		keys = append(keys, entry)
+	}
+	sort.Strings(keys)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeErrors(w http.ResponseWriter, status int, errors ...string) {
+	if errors == nil {
+		errors = []string{}
+	}
+
+	writeJSON(w, status, map[string]interface{}{"errors": errors})
+}
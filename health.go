@@ -0,0 +1,275 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	. "fmt"
+	"time"
+)
+
+const (
+	// vaultHealthPath reports standby nodes as healthy too, they forward requests to the active node
+	vaultHealthPath           = "sys/health?standbyok=true&perfstandbyok=true"
+	vaultTokenLookupSelfPath  = "auth/token/lookup-self"
+	vaultCapabilitiesSelfPath = "sys/capabilities-self"
+)
+
+// HealthReport is the result of Storage.Health.  Every check has its own Error, which is empty when it passed.
+type HealthReport struct {
+	Healthy      bool               `json:"healthy"`
+	CheckedAt    time.Time          `json:"checked_at"`
+	Server       ServerHealth       `json:"server"`
+	Token        TokenHealth        `json:"token"`
+	Capabilities CapabilitiesHealth `json:"capabilities"`
+}
+
+// ServerHealth is reported by Vault's sys/health endpoint.
+type ServerHealth struct {
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Standby     bool   `json:"standby"`
+	Version     string `json:"version,omitempty"`
+	ClusterName string `json:"cluster_name,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// TokenHealth describes the token Storage authenticates with, as reported by auth/token/lookup-self.
+type TokenHealth struct {
+	Valid     bool     `json:"valid"`
+	TTL       Duration `json:"ttl"`
+	Renewable bool     `json:"renewable"`
+	Policies  []string `json:"policies,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// CapabilitiesHealth lists the token's capabilities on the data and metadata paths of the configured prefix, as
+// reported by sys/capabilities-self.  Storage needs at least read on the former, and read (for Stat) and list on the
+// latter.
+type CapabilitiesHealth struct {
+	DataPath             string   `json:"data_path"`
+	DataCapabilities     []string `json:"data_capabilities"`
+	MetadataPath         string   `json:"metadata_path"`
+	MetadataCapabilities []string `json:"metadata_capabilities"`
+	CanRead              bool     `json:"can_read"`
+	CanReadMetadata      bool     `json:"can_read_metadata"`
+	CanList              bool     `json:"can_list"`
+	Error                string   `json:"error,omitempty"`
+}
+
+type healthResponse struct {
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Standby     bool   `json:"standby"`
+	Version     string `json:"version"`
+	ClusterName string `json:"cluster_name"`
+}
+
+type tokenLookupResponse struct {
+	Data struct {
+		TTL       int      `json:"ttl"`
+		Renewable bool     `json:"renewable"`
+		Policies  []string `json:"policies"`
+	} `json:"data"`
+}
+
+type capabilitiesInput struct {
+	Paths []string `json:"paths"`
+}
+
+// Health checks that Vault is reachable and unsealed, that the current token is valid, and that it can read, describe
+// and list keys below the configured prefix.  The report is always returned; the error joins the errors of every failed check,
+// so readiness probes only need to look at it.
+func (s *Storage) Health(ctx context.Context) (report *HealthReport, err error) {
+	ctx, end := s.startOperation(ctx, OperationHealth, "")
+	defer end(&err)
+
+	report = &HealthReport{CheckedAt: time.Now().UTC()}
+
+	serverErr := s.checkServerHealth(ctx, &report.Server)
+	tokenErr := s.checkTokenHealth(ctx, &report.Token)
+	capabilitiesErr := s.checkCapabilitiesHealth(ctx, &report.Capabilities)
+
+	err = errors.Join(serverErr, tokenErr, capabilitiesErr)
+	report.Healthy = err == nil
+
+	return report, err
+}
+
+func (s *Storage) checkServerHealth(ctx context.Context, health *ServerHealth) error {
+	result := &healthResponse{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.Health(ctx, vaultHealthPath, result)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to check vault health",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), vaultHealthPath),
+			"error", err.Error(),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		err = newVaultError(vaultHealthPath, resp, errResponse, err)
+		health.Error = err.Error()
+		return err
+	}
+
+	health.Initialized = result.Initialized
+	health.Sealed = result.Sealed
+	health.Standby = result.Standby
+	health.Version = result.Version
+	health.ClusterName = result.ClusterName
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Vault is not healthy",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), vaultHealthPath),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		err = newVaultError(vaultHealthPath, resp, errResponse, nil)
+		switch {
+		case result.Sealed:
+			err = Errorf("%w: %w", ErrVaultSealed, err)
+		case !result.Initialized:
+			err = Errorf("vault is not initialized: %w", err)
+		}
+		health.Error = err.Error()
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storage) checkTokenHealth(ctx context.Context, health *TokenHealth) error {
+	result := &tokenLookupResponse{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.Get(ctx, s.getToken(ctx), vaultTokenLookupSelfPath, result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to look up vault token",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), vaultTokenLookupSelfPath),
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		err = newVaultError(vaultTokenLookupSelfPath, resp, errResponse, err)
+		health.Error = err.Error()
+		return err
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to look up vault token",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), vaultTokenLookupSelfPath),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		err = newVaultError(vaultTokenLookupSelfPath, resp, errResponse, nil)
+		health.Error = err.Error()
+		return err
+	}
+
+	health.Valid = true
+	health.TTL = Duration(time.Duration(result.Data.TTL) * time.Second)
+	health.Renewable = result.Data.Renewable
+	health.Policies = result.Data.Policies
+
+	return nil
+}
+
+func (s *Storage) checkCapabilitiesHealth(ctx context.Context, health *CapabilitiesHealth) error {
+	health.DataPath = s.vaultDataPath("")
+	health.MetadataPath = s.vaultMetadataPath("")
+
+	capabilities, err := s.capabilities(ctx, health.DataPath, health.MetadataPath)
+	if err != nil {
+		health.Error = err.Error()
+		return err
+	}
+
+	health.DataCapabilities = capabilities[health.DataPath]
+	health.MetadataCapabilities = capabilities[health.MetadataPath]
+	health.CanRead = hasCapability(health.DataCapabilities, "read")
+	health.CanReadMetadata = hasCapability(health.MetadataCapabilities, "read")
+	health.CanList = hasCapability(health.MetadataCapabilities, "list")
+
+	switch {
+	case !health.CanRead:
+		err = Errorf("%w: no read capability on %s", ErrPermissionDenied, health.DataPath)
+	case !health.CanReadMetadata:
+		err = Errorf("%w: no read capability on %s", ErrPermissionDenied, health.MetadataPath)
+	case !health.CanList:
+		err = Errorf("%w: no list capability on %s", ErrPermissionDenied, health.MetadataPath)
+	}
+	if err != nil {
+		health.Error = err.Error()
+	}
+
+	return err
+}
+
+// capabilities returns the current token's capabilities on each of paths
+func (s *Storage) capabilities(ctx context.Context, paths ...string) (map[string][]string, error) {
+	body := &capabilitiesInput{Paths: paths}
+	result := map[string]interface{}{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.PostRaw(ctx, s.getToken(ctx), vaultCapabilitiesSelfPath, body, &result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to look up vault token capabilities",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), vaultCapabilitiesSelfPath),
+			"paths", paths,
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, newVaultError(vaultCapabilitiesSelfPath, resp, errResponse, err)
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to look up vault token capabilities",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), vaultCapabilitiesSelfPath),
+			"paths", paths,
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, newVaultError(vaultCapabilitiesSelfPath, resp, errResponse, nil)
+	}
+
+	// The capabilities of every path are returned as a top-level field named after it (and under "data" on recent
+	// versions of Vault)
+	if data, ok := result["data"].(map[string]interface{}); ok {
+		result = data
+	}
+
+	capabilities := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		list, _ := result[path].([]interface{})
+		for _, capability := range list {
+			if name, ok := capability.(string); ok {
+				capabilities[path] = append(capabilities[path], name)
+			}
+		}
+	}
+
+	return capabilities, nil
+}
+
+// hasCapability reports whether 'capability' is granted, "root" grants everything and "deny" nothing
+func hasCapability(capabilities []string, capability string) bool {
+	granted := false
+	for _, c := range capabilities {
+		switch c {
+		case "deny":
+			return false
+		case "root", capability:
+			granted = true
+		}
+	}
+
+	return granted
+}
@@ -0,0 +1,44 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestHealthCapabilities(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         []string
+		metadata     []string
+		healthy      bool
+		readMetadata bool
+	}{
+		{name: "policy", data: []string{"create", "read", "update"}, metadata: []string{"read", "list", "update", "delete"}, healthy: true, readMetadata: true},
+		{name: "root", data: []string{"root"}, metadata: []string{"root"}, healthy: true, readMetadata: true},
+		{name: "no metadata read", data: []string{"read"}, metadata: []string{"list"}},
+		{name: "no list", data: []string{"read"}, metadata: []string{"read"}, readMetadata: true},
+		{name: "denied", data: []string{"read"}, metadata: []string{"read", "list", "deny"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, vault := newTestStorage(t, &Config{})
+			vault.capabilities = map[string][]string{
+				storage.vaultDataPath(""):     test.data,
+				storage.vaultMetadataPath(""): test.metadata,
+			}
+
+			report, err := storage.Health(context.Background())
+			if report.Healthy != test.healthy || (err == nil) != test.healthy {
+				t.Fatalf("Health() = %+v, %v, want healthy=%t", report, err, test.healthy)
+			}
+			if !test.healthy && !errors.Is(err, ErrPermissionDenied) {
+				t.Errorf("Health() = %v, want ErrPermissionDenied", err)
+			}
+			if report.Capabilities.CanReadMetadata != test.readMetadata {
+				t.Errorf("Health().Capabilities.CanReadMetadata = %t, want %t", report.Capabilities.CanReadMetadata, test.readMetadata)
+			}
+		})
+	}
+}
@@ -1,10 +1,17 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/resty.v1"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 )
 
@@ -22,11 +29,15 @@ func NewClient(insecureSkipVerify bool) *Client {
 		TLSHandshakeTimeout: 5 * time.Second,
 		TLSClientConfig:     &tls.Config{InsecureSkipVerify: insecureSkipVerify},
 	})
+	c.tracer = trace.NewNoopTracerProvider().Tracer("")
 	return c
 }
 
 type Client struct {
 	resty *resty.Client
+
+	// tracer starts a span for every request, see SetTracer
+	tracer trace.Tracer
 }
 
 func (c *Client) SetHostUrl(url string) *Client {
@@ -34,37 +45,97 @@ func (c *Client) SetHostUrl(url string) *Client {
 	return c
 }
 
-func (c *Client) Get(token, path string, result, error interface{}) (*resty.Response, error) {
-	return c.resty.R().SetHeader("X-Vault-Token", token).SetResult(result).SetError(error).Get(path)
+// SetNamespace sends every request to the given Vault Enterprise namespace
+func (c *Client) SetNamespace(namespace string) *Client {
+	c.resty.SetHeader("X-Vault-Namespace", namespace)
+	return c
+}
+
+// SetRootCertificate trusts the CA certificates in the PEM file at path, instead of the system's root CAs
+func (c *Client) SetRootCertificate(path string) error {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %s", path)
+	}
+
+	transport, ok := c.resty.GetClient().Transport.(*http.Transport)
+	if !ok {
+		return errors.New("unsupported transport")
+	}
+	transport.TLSClientConfig.RootCAs = pool
+
+	return nil
+}
+
+// SetTracer sets the tracer used to start a span for every request, as a child of the span in the request's context
+func (c *Client) SetTracer(tracer trace.Tracer) *Client {
+	c.tracer = tracer
+	return c
+}
+
+func (c *Client) Get(ctx context.Context, token, path string, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodGet, path, c.resty.R().SetHeader("X-Vault-Token", token).SetResult(result).SetError(error))
+}
+
+func (c *Client) GetVersion(ctx context.Context, token, path string, version int, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodGet, path, c.resty.R().SetHeader("X-Vault-Token", token).SetQueryParam("version", strconv.Itoa(version)).SetResult(result).SetError(error))
+}
+
+func (c *Client) List(ctx context.Context, token, path string, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, "LIST", path, c.resty.R().SetHeader("X-Vault-Token", token).SetResult(result).SetError(error))
+}
+
+func (c *Client) Put(ctx context.Context, token, path string, body, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodPut, path, c.resty.R().SetHeader("X-Vault-Token", token).SetBody(map[string]interface{}{"data": body}).SetResult(result).SetError(error))
+}
+
+func (c *Client) Post(ctx context.Context, token, path string, body, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodPost, path, c.resty.R().SetHeader("X-Vault-Token", token).SetBody(map[string]interface{}{"data": body}).SetResult(result).SetError(error))
+}
+
+// PostCAS works like Post, but when cas is non-nil the write is made with check-and-set against that version.
+func (c *Client) PostCAS(ctx context.Context, token, path string, body interface{}, cas *int, result, error interface{}) (*resty.Response, error) {
+	payload := map[string]interface{}{"data": body}
+	if cas != nil {
+		payload["options"] = map[string]interface{}{"cas": *cas}
+	}
+	return c.execute(ctx, http.MethodPost, path, c.resty.R().SetHeader("X-Vault-Token", token).SetBody(payload).SetResult(result).SetError(error))
 }
 
-func (c *Client) List(token, path string, result, error interface{}) (*resty.Response, error) {
-	return c.resty.R().SetHeader("X-Vault-Token", token).SetResult(result).SetError(error).Execute("LIST", path)
+func (c *Client) PutRaw(ctx context.Context, token, path string, body, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodPut, path, c.resty.R().SetHeader("X-Vault-Token", token).SetBody(body).SetResult(result).SetError(error))
 }
 
-func (c *Client) Put(token, path string, body, result, error interface{}) (*resty.Response, error) {
-	return c.resty.R().SetHeader("X-Vault-Token", token).SetBody(map[string]interface{}{"data": body}).SetResult(result).SetError(error).Put(path)
+func (c *Client) PostRaw(ctx context.Context, token, path string, body, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodPost, path, c.resty.R().SetHeader("X-Vault-Token", token).SetBody(body).SetResult(result).SetError(error))
 }
 
-func (c *Client) Post(token, path string, body, result, error interface{}) (*resty.Response, error) {
-	return c.resty.R().SetHeader("X-Vault-Token", token).SetBody(map[string]interface{}{"data": body}).SetResult(result).SetError(error).Post(path)
+func (c *Client) ApproleLogin(ctx context.Context, path string, body, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodPost, path, c.resty.R().SetBody(body).SetResult(result).SetError(error))
 }
 
-func (c *Client) ApproleLogin(path string, body, result, error interface{}) (*resty.Response, error) {
-	return c.resty.R().SetBody(body).SetResult(result).SetError(error).Post(path)
+func (c *Client) ApproleLogout(ctx context.Context, token, path string, body, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodPost, path, c.resty.R().SetHeader("X-Vault-Token", token).SetBody(body).SetResult(result).SetError(error))
 }
 
-func (c *Client) ApproleLogout(token, path string, body, result, error interface{}) (*resty.Response, error) {
-	return c.resty.R().SetHeader("X-Vault-Token", token).SetBody(body).SetResult(result).SetError(error).Post(path)
+// Health is unauthenticated, and decodes the body in to result whatever the status code (sys/health reports sealed or
+// uninitialized Vaults with an error status)
+func (c *Client) Health(ctx context.Context, path string, result interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodGet, path, c.resty.R().SetResult(result).SetError(result))
 }
 
-func (c *Client) Delete(token, path string, result, error interface{}) (*resty.Response, error) {
-	return c.resty.R().SetHeader("X-Vault-Token", token).SetResult(result).SetError(error).Delete(path)
+func (c *Client) Delete(ctx context.Context, token, path string, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodDelete, path, c.resty.R().SetHeader("X-Vault-Token", token).SetResult(result).SetError(error))
 }
 
-func (c *Client) Merge(token, path string, body, result, error interface{}) (*resty.Response, error) {
-	return c.resty.R().SetHeaders(map[string]string{
+func (c *Client) Merge(ctx context.Context, token, path string, body, result, error interface{}) (*resty.Response, error) {
+	return c.execute(ctx, http.MethodPatch, path, c.resty.R().SetHeaders(map[string]string{
 		"Content-Type":  "application/merge-patch+json",
 		"X-Vault-Token": token,
-	}).SetBody(map[string]interface{}{"data": body}).SetResult(result).SetError(error).Patch(path)
+	}).SetBody(map[string]interface{}{"data": body}).SetResult(result).SetError(error))
 }
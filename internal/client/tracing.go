@@ -0,0 +1,37 @@
+package client
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/resty.v1"
+	"net/http"
+)
+
+// execute sends the request in a span carrying the Vault path and status code
+func (c *Client) execute(ctx context.Context, method, path string, r *resty.Request) (*resty.Response, error) {
+	ctx, span := c.tracer.Start(ctx, "vault "+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", method),
+			attribute.String("vault.path", path),
+		),
+	)
+	defer span.End()
+
+	resp, err := r.SetContext(ctx).Execute(method, path)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode()))
+	if resp.StatusCode() >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, resp.Status())
+	}
+
+	return resp, err
+}
@@ -0,0 +1,136 @@
+package certmagic_vault_storage
+
+import (
+	"errors"
+	. "fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidKey is returned when a CertMagic key cannot be safely mapped to a Vault path (e.g. it tries to traverse
+// out of the configured path prefix).
+var ErrInvalidKey = errors.New("invalid storage key")
+
+const upperHex = "0123456789ABCDEF"
+
+// validateKey rejects keys that would escape the configured path prefix or produce ambiguous Vault paths, and keys in
+// (or named like) the directories chunks are stored in, which List hides.  A single trailing slash is allowed, since
+// List() is called with "directory" prefixes.
+func validateKey(key string) error {
+	segments := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	if len(segments) == 1 && segments[0] == "" {
+		return nil
+	}
+
+	for _, segment := range segments {
+		switch segment {
+		case "":
+			return Errorf("%w: empty path segment in %q", ErrInvalidKey, key)
+		case ".", "..":
+			return Errorf("%w: path traversal in %q", ErrInvalidKey, key)
+		}
+		if strings.HasSuffix(segment, chunksSuffix) {
+			return Errorf("%w: %q is reserved for chunks in %q", ErrInvalidKey, chunksSuffix, key)
+		}
+	}
+
+	return nil
+}
+
+// encodeKey maps a CertMagic key to the path it is stored at in Vault.  Every "/" separated segment is kept, but any
+// byte outside of the set CertMagic itself generates (letters, digits and "-._~@") is percent-encoded, so keys
+// containing "?", "#", "%", spaces or wildcards end up as a single, literal path segment.
+func encodeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = encodeKeySegment(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func encodeKeySegment(segment string) string {
+	var sb strings.Builder
+	for i := 0; i < len(segment); i++ {
+		b := segment[i]
+		if shouldEscapeKeyByte(b) {
+			sb.WriteByte('%')
+			sb.WriteByte(upperHex[b>>4])
+			sb.WriteByte(upperHex[b&15])
+			continue
+		}
+		sb.WriteByte(b)
+	}
+
+	return sb.String()
+}
+
+func shouldEscapeKeyByte(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return false
+	case b == '-' || b == '.' || b == '_' || b == '~' || b == '@':
+		return false
+	}
+
+	return true
+}
+
+// storedKey is key as Vault stores it.  secretPathFormatType lowercases the whole path, so keys are case-folded: keys
+// that only differ in case are the same key, and List returns them lowercased.  CertMagic lowercases the names it puts
+// in keys itself (see certmagic.KeyBuilder.Safe), so in practice this only affects keys built by hand.
+func storedKey(key string) string {
+	return strings.ToLower(encodeKey(key))
+}
+
+// decodeKey is the inverse of encodeKey, used to turn the entries returned from a Vault LIST back in to CertMagic keys.
+func decodeKey(encoded string) (string, error) {
+	key, err := url.PathUnescape(encoded)
+	if err != nil {
+		return "", Errorf("%w: %s", ErrInvalidKey, err.Error())
+	}
+
+	return key, nil
+}
+
+// requestPath escapes an encoded key for use in the request URL.  Vault decodes the request path before storing it,
+// so the "%" of our own encoding has to survive one round of URL decoding.
+func requestPath(encoded string) string {
+	return strings.ReplaceAll(encoded, "%", "%25")
+}
+
+// keyType classifies a key by what CertMagic stores in it
+type keyType string
+
+const (
+	keyTypeCertificate keyType = "certificate"
+	keyTypePrivateKey  keyType = "private_key"
+	keyTypeMetadata    keyType = "metadata"
+	keyTypeAccount     keyType = "account"
+	keyTypeOCSP        keyType = "ocsp"
+	keyTypeLock        keyType = "lock"
+	keyTypeChunk       keyType = "chunk"
+	keyTypeOther       keyType = "other"
+)
+
+// keyTypeOf derives the keyType from the layout CertMagic uses for its keys (see certmagic.KeyBuilder)
+func keyTypeOf(key string) keyType {
+	switch {
+	case strings.Contains(key, chunksSuffix+"/"):
+		return keyTypeChunk
+	case strings.HasSuffix(key, ".lock"):
+		return keyTypeLock
+	case strings.HasPrefix(key, "acme/") && strings.Contains(key, "/users/"):
+		return keyTypeAccount
+	case strings.HasPrefix(key, "ocsp/"):
+		return keyTypeOCSP
+	case strings.HasSuffix(key, ".crt"):
+		return keyTypeCertificate
+	case strings.HasSuffix(key, ".key"):
+		return keyTypePrivateKey
+	case strings.HasSuffix(key, ".json"):
+		return keyTypeMetadata
+	}
+
+	return keyTypeOther
+}
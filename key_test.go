@@ -0,0 +1,76 @@
+package certmagic_vault_storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeyEncoding(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		invalid bool
+		encoded string
+		request string
+		// listed is what List returns for key, after Vault case-folded the path
+		listed string
+	}{
+		{name: "plain", key: "certificates/acme/example.com/example.com.crt", encoded: "certificates/acme/example.com/example.com.crt", request: "certificates/acme/example.com/example.com.crt", listed: "certificates/acme/example.com/example.com.crt"},
+		{name: "wildcard", key: "certificates/acme/*.example.com/*.example.com.key", encoded: "certificates/acme/%2A.example.com/%2A.example.com.key", request: "certificates/acme/%252A.example.com/%252A.example.com.key", listed: "certificates/acme/*.example.com/*.example.com.key"},
+		{name: "mixed case", key: "certificates/*.Example.com", encoded: "certificates/%2A.Example.com", request: "certificates/%252A.Example.com", listed: "certificates/*.example.com"},
+		{name: "question mark", key: "a?b", encoded: "a%3Fb", request: "a%253Fb", listed: "a?b"},
+		{name: "hash", key: "a#b", encoded: "a%23b", request: "a%2523b", listed: "a#b"},
+		{name: "percent", key: "a%2Fb", encoded: "a%252Fb", request: "a%25252Fb", listed: "a%2fb"},
+		{name: "space", key: "a b/c d", encoded: "a%20b/c%20d", request: "a%2520b/c%2520d", listed: "a b/c d"},
+		{name: "unreserved", key: "acme/users/me@example.com/a-b_c~d.json", encoded: "acme/users/me@example.com/a-b_c~d.json", request: "acme/users/me@example.com/a-b_c~d.json", listed: "acme/users/me@example.com/a-b_c~d.json"},
+		{name: "root", key: "", encoded: "", request: "", listed: ""},
+		{name: "trailing slash", key: "certificates/", encoded: "certificates/", request: "certificates/", listed: "certificates/"},
+		{name: "dot dot", key: "certificates/../secret", invalid: true},
+		{name: "leading dot dot", key: "../secret", invalid: true},
+		{name: "dot", key: "certificates/./example.com", invalid: true},
+		{name: "empty segment", key: "certificates//example.com", invalid: true},
+		{name: "leading slash", key: "/certificates", invalid: true},
+		{name: "chunks directory", key: "certificates/example.com.crt.chunks/0", invalid: true},
+		{name: "chunks name", key: "certificates/example.com.chunks", invalid: true},
+		{name: "only slash", key: "/", invalid: false, encoded: "/", request: "/", listed: "/"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateKey(test.key)
+			if test.invalid {
+				if !errors.Is(err, ErrInvalidKey) {
+					t.Fatalf("validateKey(%q) = %v, want ErrInvalidKey", test.key, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateKey(%q) = %v", test.key, err)
+			}
+
+			if encoded := encodeKey(test.key); encoded != test.encoded {
+				t.Errorf("encodeKey(%q) = %q, want %q", test.key, encoded, test.encoded)
+			}
+
+			if request := requestPath(encodeKey(test.key)); request != test.request {
+				t.Errorf("requestPath(%q) = %q, want %q", test.key, request, test.request)
+			}
+
+			decoded, err := decodeKey(encodeKey(test.key))
+			if err != nil || decoded != test.key {
+				t.Errorf("decodeKey(encodeKey(%q)) = %q, %v", test.key, decoded, err)
+			}
+
+			listed, err := decodeKey(storedKey(test.key))
+			if err != nil || listed != test.listed {
+				t.Errorf("decodeKey(storedKey(%q)) = %q, %v, want %q", test.key, listed, err, test.listed)
+			}
+		})
+	}
+}
+
+func TestDecodeKeyRejectsMalformedEscapes(t *testing.T) {
+	if _, err := decodeKey("a%zzb"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("decodeKey() = %v, want ErrInvalidKey", err)
+	}
+}
@@ -0,0 +1,107 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	"github.com/caddyserver/certmagic"
+	"io/fs"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestListMatchesFileStorage stores the same tree in certmagic.FileStorage and in Storage, and expects both to list it
+// the same way
+func TestListMatchesFileStorage(t *testing.T) {
+	ctx := context.Background()
+
+	files := &certmagic.FileStorage{Path: t.TempDir()}
+	vault, _ := newTestStorage(t, &Config{MaxChunkSize: 64})
+
+	tree := map[string]string{
+		"acme/acme-v02.api.letsencrypt.org-directory/users/me@example.com/me.json":                             "{}",
+		"certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.crt":                      "certificate",
+		"certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.key":                      "key",
+		"certificates/acme-v02.api.letsencrypt.org-directory/wildcard_.example.com/wildcard_.example.com.json": strings.Repeat("x", 200),
+		"certificates/zerossl/*.example.org/*.example.org.crt":                                                 "certificate",
+		"ocsp/example.com-a1b2c3": "staple",
+		"last_clean.json":         "{}",
+	}
+	for key, value := range tree {
+		if err := files.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		if err := vault.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	prefixes := []string{
+		"",
+		"certificates",
+		"certificates/",
+		"certificates/acme-v02.api.letsencrypt.org-directory",
+		"certificates/acme-v02.api.letsencrypt.org-directory/wildcard_.example.com",
+		"certificates/zerossl/*.example.org/",
+		"ocsp",
+		"missing",
+		"/",
+		"last_clean.json",
+		"ocsp/example.com-a1b2c3",
+		"certificates/acme-v02.api.letsencrypt.org-directory/wildcard_.example.com/wildcard_.example.com.json",
+	}
+	for _, prefix := range prefixes {
+		for _, recursive := range []bool{false, true} {
+			want, wantErr := files.List(ctx, prefix, recursive)
+			got, gotErr := vault.List(ctx, prefix, recursive)
+
+			if errors.Is(wantErr, fs.ErrNotExist) != errors.Is(gotErr, fs.ErrNotExist) {
+				t.Errorf("List(%q, %t) error = %v, FileStorage returned %v", prefix, recursive, gotErr, wantErr)
+				continue
+			}
+			if wantErr != nil {
+				continue
+			}
+			if gotErr != nil {
+				t.Errorf("List(%q, %t) = %v", prefix, recursive, gotErr)
+				continue
+			}
+
+			sort.Strings(want)
+			if (len(got) > 0 || len(want) > 0) && !reflect.DeepEqual(got, want) {
+				t.Errorf("List(%q, %t) = %q, FileStorage returned %q", prefix, recursive, got, want)
+			}
+		}
+	}
+}
+
+// TestListRecursiveError checks that a recursive List fails, rather than hangs or returns a partial listing, when one
+// of the directories can't be listed
+func TestListRecursiveError(t *testing.T) {
+	ctx := context.Background()
+
+	for _, concurrency := range []int{1, 2, 16} {
+		storage, vault := newTestStorage(t, &Config{ListConcurrency: concurrency})
+		for i := 0; i < 20; i++ {
+			key := path.Join("certificates", strconv.Itoa(i%4), strconv.Itoa(i), "example.com.crt")
+			if err := storage.Store(ctx, key, []byte("certificate")); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		keys, err := storage.List(ctx, "", true)
+		if err != nil || len(keys) != 1+4+20+20 {
+			t.Fatalf("List() with %d workers = %d keys, %v, want %d", concurrency, len(keys), err, 1+4+20+20)
+		}
+
+		vault.deny = func(method, _, path string, _ bool) bool {
+			return method == "LIST" && strings.TrimSuffix(path, "/") == "certmagic/certificates/3/7"
+		}
+		if keys, err := storage.List(ctx, "", true); !errors.Is(err, ErrPermissionDenied) {
+			t.Errorf("List() with %d workers and a denied directory = %d keys, %v, want ErrPermissionDenied", concurrency, len(keys), err)
+		}
+	}
+}
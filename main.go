@@ -2,13 +2,19 @@ package certmagic_vault_storage
 
 import (
 	"context"
+	"errors"
 	. "fmt"
 	"github.com/caddyserver/certmagic"
 	"github.com/mywordpress-io/certmagic-vault-storage/internal/client"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"io/fs"
 	"net/http"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -35,10 +41,32 @@ func NewStorage(config StorageConfigInterface) *Storage {
 	s := new(Storage)
 	s.config = config
 	s.logger = config.GetLogger()
-	s.client = client.NewClient(s.config.GetInsecureSkipVerify()).SetHostUrl(s.config.GetVaultBaseUrl())
+	s.tracer = s.tracerProvider().Tracer(tracerName)
+	s.client = client.NewClient(s.config.GetInsecureSkipVerify()).SetHostUrl(s.config.GetVaultBaseUrl()).SetTracer(s.tracer)
+	if config, ok := s.config.(NamespaceConfigInterface); ok && config.GetNamespace() != "" {
+		s.client.SetNamespace(config.GetNamespace())
+	}
+	if config, ok := s.config.(TLSConfigInterface); ok && config.GetCACert() != "" {
+		if err := s.client.SetRootCertificate(config.GetCACert()); err != nil {
+			s.logger.Errorw("[ERROR] Unable to load CA certificate", "ca_cert", config.GetCACert(), "error", err.Error())
+		}
+	}
+	if s.unsafeDebug() {
+		s.logger.Warn("Unsafe debug is enabled, Vault responses are logged unredacted and may contain private keys and tokens")
+	}
 	return s
 }
 
+// NewStorageE works like NewStorage, but validates config first.  The returned error wraps ErrInvalidConfig, and lists
+// every problem found rather than only the first one.
+func NewStorageE(config StorageConfigInterface) (*Storage, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	return NewStorage(config), nil
+}
+
 // Storage is the main object passed to CertMagic that implements the "Storage" interface.
 type Storage struct {
 	config StorageConfigInterface
@@ -54,17 +82,41 @@ type Storage struct {
 
 	// logger Zap sugared logger
 	logger *zap.SugaredLogger
+
+	// tracer starts the spans of Storage operations, see TracingConfigInterface
+	tracer trace.Tracer
 }
 
-func (s *Storage) Store(_ context.Context, key string, value []byte) error {
+func (s *Storage) Store(ctx context.Context, key string, value []byte) (err error) {
+	ctx, end := s.startOperation(ctx, OperationStore, key)
+	defer end(&err)
+
+	version := 0
+	defer s.audit(ctx, OperationStore, key, &version, &err)
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
 	s.logger.Debugw("Store() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)))
 
-	secret := &certificateSecret{
-		Certmagic: certMagicCertificateSecret{Data: value},
+	cas, err := s.casVersion(ctx, key)
+	if err != nil {
+		return err
 	}
-	result := &response{}
+
+	secret, err := s.encodeValue(ctx, key, value)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storeChunks(ctx, key, secret); err != nil {
+		return err
+	}
+
+	result := &writeResponse{}
 	errResponse := &errorResponse{}
-	resp, err := s.client.Post(s.getToken(), s.vaultDataPath(key), secret, result, errResponse)
+	resp, err := s.client.PostCAS(ctx, s.getToken(ctx), s.vaultDataPath(key), secret, cas, result, errResponse)
 	if err != nil {
 		s.logger.Errorw(
 			"[ERROR] Unable to store certificate",
@@ -72,9 +124,9 @@ func (s *Storage) Store(_ context.Context, key string, value []byte) error {
 			"error", err.Error(),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
-		return err
+		return newVaultError(s.vaultDataPath(key), resp, errResponse, err)
 	}
 
 	if resp.IsError() {
@@ -83,20 +135,30 @@ func (s *Storage) Store(_ context.Context, key string, value []byte) error {
 			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
-		return errResponse.Error()
+		return newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
 	}
 
+	version = result.Data.Version
+	s.describeKey(ctx, key, value, result.Data.Version)
+
 	return nil
 }
 
-func (s *Storage) Load(_ context.Context, key string) ([]byte, error) {
+func (s *Storage) Load(ctx context.Context, key string) (value []byte, err error) {
+	ctx, end := s.startOperation(ctx, OperationLoad, key)
+	defer end(&err)
+
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
 	s.logger.Debugw("Load() from url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)))
 
 	result := &response{}
 	errResponse := &errorResponse{}
-	resp, err := s.client.Get(s.getToken(), s.vaultDataPath(key), result, errResponse)
+	resp, err := s.client.Get(ctx, s.getToken(ctx), s.vaultDataPath(key), result, errResponse)
 	if err != nil {
 		s.logger.Errorw(
 			"[ERROR] Unable to load certificate",
@@ -104,9 +166,9 @@ func (s *Storage) Load(_ context.Context, key string) ([]byte, error) {
 			"error", err.Error(),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
-		return nil, err
+		return nil, newVaultError(s.vaultDataPath(key), resp, errResponse, err)
 	}
 
 	if resp.IsError() && resp.StatusCode() != http.StatusNotFound {
@@ -115,174 +177,402 @@ func (s *Storage) Load(_ context.Context, key string) ([]byte, error) {
 			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
+		return nil, newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
 	}
 
 	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
-		return nil, fs.ErrNotExist
+		return nil, newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
 	}
 
-	return result.Data.Data.Certmagic.Data, nil
+	return s.decodeValue(ctx, key, &result.Data.Data)
 }
 
-func (s *Storage) Delete(_ context.Context, key string) error {
-	s.logger.Debugw("Delete() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)))
+// Delete removes key from Vault according to the configured DeleteMode (see DeleteModeConfigInterface).  By default
+// the key's metadata is deleted, which permanently removes every version.
+func (s *Storage) Delete(ctx context.Context, key string) (err error) {
+	ctx, end := s.startOperation(ctx, OperationDelete, key)
+	defer end(&err)
 
-	result := &response{}
-	errResponse := &errorResponse{}
-	resp, err := s.client.Delete(s.getToken(), s.vaultMetadataPath(key), result, errResponse)
-	if err != nil {
-		s.logger.Errorw(
-			"[ERROR] Unable to delete certificate",
-			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
-			"error", err.Error(),
-			"vault_errors", s.vaultErrorString(errResponse),
-			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
-		)
+	version := 0
+	defer s.audit(ctx, OperationDelete, key, &version, &err)
+
+	if err := validateKey(key); err != nil {
 		return err
 	}
 
-	if resp.IsError() && resp.StatusCode() != http.StatusNotFound {
-		s.logger.Errorw(
-			"[ERROR] Unable to delete certificate",
-			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
-			"vault_errors", s.vaultErrorString(errResponse),
-			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
-		)
+	switch s.deleteMode() {
+	case DeleteModeSoft:
+		version = s.auditedVersion(ctx, key)
+		return s.softDelete(ctx, key)
+	case DeleteModeDestroy:
+		version, err = s.destroyLatest(ctx, key)
+		return err
 	}
 
-	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
-		return fs.ErrNotExist
+	version = s.auditedVersion(ctx, key)
+	if err := s.deleteMetadata(ctx, key); err != nil {
+		return err
 	}
 
-	return nil
+	return s.deleteChunks(ctx, key)
 }
 
-func (s *Storage) Exists(_ context.Context, key string) bool {
+// Exists reports whether key exists.  CertMagic has no way of handling errors here, so when Vault can't be asked
+// (network failure, permission denied, sealed Vault...) the answer is logged as unknown, and the configured
+// ExistsErrorPolicy decides what is returned.  Use ExistsE to handle those errors yourself.
+func (s *Storage) Exists(ctx context.Context, key string) bool {
+	exists, err := s.ExistsE(ctx, key)
+	if err == nil {
+		return exists
+	}
+
+	if errors.Is(err, ErrInvalidKey) {
+		return false
+	}
+
+	policy := s.existsErrorPolicy()
+	s.logger.Warnw(
+		"Unable to determine whether certificate exists",
+		"key", key,
+		"error", err.Error(),
+		"policy", policy,
+	)
+
+	return policy == ExistsErrorReportExists
+}
+
+// ExistsE works like Exists, but returns an error when it cannot tell whether key exists, rather than guessing.
+func (s *Storage) ExistsE(ctx context.Context, key string) (exists bool, err error) {
+	ctx, end := s.startOperation(ctx, OperationExists, key)
+	defer end(&err)
+
+	if err := validateKey(key); err != nil {
+		return false, err
+	}
+
 	s.logger.Debugw("Exists() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)))
 
 	result := &response{}
 	errResponse := &errorResponse{}
-	resp, err := s.client.Get(s.getToken(), s.vaultDataPath(key), result, errResponse)
+	resp, err := s.client.Get(ctx, s.getToken(ctx), s.vaultDataPath(key), result, errResponse)
 	if err != nil {
-		return false
+		return false, newVaultError(s.vaultDataPath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
+		return false, nil
 	}
 
 	if resp.IsError() {
-		return false
+		return false, newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
+	}
+
+	return hasValue(&result.Data.Data), nil
+}
+
+// ExistsErrorPolicy decides what Storage.Exists returns when it cannot tell whether a key exists.
+type ExistsErrorPolicy string
+
+const (
+	// ExistsErrorReportMissing makes Exists return false (the default).  During a Vault outage this makes CertMagic
+	// believe certificates are missing, and try to issue new ones.
+	ExistsErrorReportMissing ExistsErrorPolicy = "missing"
+
+	// ExistsErrorReportExists makes Exists return true, so CertMagic doesn't issue new certificates (and burn ACME rate
+	// limits) while Vault is unavailable.
+	ExistsErrorReportExists ExistsErrorPolicy = "exists"
+)
+
+// ExistsErrorPolicyConfigInterface can optionally be implemented by a StorageConfigInterface to choose the
+// ExistsErrorPolicy.
+type ExistsErrorPolicyConfigInterface interface {
+	GetExistsErrorPolicy() ExistsErrorPolicy
+}
+
+func (s *Storage) existsErrorPolicy() ExistsErrorPolicy {
+	if config, ok := s.config.(ExistsErrorPolicyConfigInterface); ok && config.GetExistsErrorPolicy() != "" {
+		return config.GetExistsErrorPolicy()
 	}
 
-	return len(result.Data.Data.Certmagic.Data) > 0
+	return ExistsErrorReportMissing
 }
 
-// List will recursively list all items at prefix if recursive==true.  If not, it will just return a list of items that
-// are NOT "directories" in Vault.  Note that Vault's kv-v2 engine doesn't really have the idea of directories, they
-// are more like paths in a tree (I guess?).
+// List returns the keys at prefix, matching the behaviour of certmagic.FileStorage: every returned key is the full key
+// (prefix included), and "directories" (which Vault's kv-v2 engine only has as shared path prefixes) are returned as
+// keys too, without a trailing slash.  When recursive==true, everything below the directories is included as well.
+// Listing a key that isn't also a directory returns no keys, and listing "/" lists the root, with the keys starting
+// with a slash.
 //
-// Caveats:
-//   - When recursive==false, we ONLY include item that do NOT have a trailing slash
-//   - When recursive==true, we include ALL items from the specified prefix that do NOT have a trailing slash
-func (s *Storage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
-	s.logger.Debugw("List() at url", "operation", "list", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(prefix)), "recursive", recursive)
+// When recursive==true, "directories" are listed concurrently (see ListConcurrencyConfigInterface), and the first
+// error or cancellation of ctx aborts all outstanding requests.  Results are always sorted.
+func (s *Storage) List(ctx context.Context, prefix string, recursive bool) (keys []string, err error) {
+	ctx, end := s.startOperation(ctx, OperationList, prefix)
+	defer end(&err)
 
-	result := &listResponse{}
-	errResponse := &errorResponse{}
-	resp, err := s.client.List(s.getToken(), s.vaultMetadataPath(prefix), result, errResponse)
+	if err := validateKey(prefix); err != nil {
+		return []string{}, err
+	}
+
+	if recursive {
+		keys, err = s.listRecursive(ctx, s.getToken(ctx), prefix)
+	} else {
+		keys, _, err = s.listDirectory(ctx, s.getToken(ctx), prefix)
+	}
 	if err != nil {
-		s.logger.Errorw(
-			"[ERROR] Unable to list certificates",
-			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(prefix)),
-			"error", err.Error(),
-			"vault_errors", s.vaultErrorString(errResponse),
-			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
-		)
 		return []string{}, err
 	}
 
-	// Recursively list all items in vault
-	items := make([]string, 0)
-	for _, entry := range result.Data.Keys {
-		path := entry
-		if strings.HasSuffix(prefix, "/") {
-			path = Sprintf("%s%s", prefix, entry)
-		} else {
-			//path = Sprintf("%s/%s", prefix, entry)
+	// If we get nothing back, 'prefix' is either a key, which like a file has nothing to list, or does not exist
+	if len(keys) == 0 {
+		if prefix == "" || strings.HasSuffix(prefix, "/") {
+			return []string{}, fs.ErrNotExist
 		}
-
-		if !strings.HasSuffix(path, "/") {
-			items = append(items, path)
+		if _, err := s.readMetadata(ctx, prefix); err != nil {
+			return []string{}, err
 		}
+		return []string{}, nil
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListConcurrencyConfigInterface can optionally be implemented by a StorageConfigInterface to limit the number of LIST
+// requests a recursive List makes in parallel (defaults to 8).
+type ListConcurrencyConfigInterface interface {
+	GetListConcurrency() int
+}
+
+const defaultListConcurrency = 8
+
+func (s *Storage) listConcurrency() int {
+	if config, ok := s.config.(ListConcurrencyConfigInterface); ok && config.GetListConcurrency() > 0 {
+		return config.GetListConcurrency()
+	}
 
-		if recursive && strings.HasSuffix(entry, "/") {
-			results, err := s.List(ctx, path, recursive)
-			if err != nil {
-				return []string{}, err
+	return defaultListConcurrency
+}
+
+// listRecursive walks the tree below prefix with listConcurrency() workers, each listing one "directory" at a time
+// from a shared queue
+func (s *Storage) listRecursive(ctx context.Context, token, prefix string) ([]string, error) {
+	walkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		ready    = sync.NewCond(&mu)
+		wg       sync.WaitGroup
+		queue    = []string{prefix}
+		pending  = 1 // directories queued or being listed
+		items    = make([]string, 0)
+		firstErr error
+	)
+
+	worker := func() {
+		defer wg.Done()
+
+		for {
+			mu.Lock()
+			for len(queue) == 0 && pending > 0 && firstErr == nil {
+				ready.Wait()
+			}
+			if pending == 0 || firstErr != nil {
+				mu.Unlock()
+				return
 			}
+			dir := queue[0]
+			queue = queue[1:]
+			mu.Unlock()
 
-			items = append(items, results...)
+			keys, dirs, err := s.listDirectory(walkCtx, token, dir)
+
+			mu.Lock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+			if err == nil {
+				items = append(items, keys...)
+				queue = append(queue, dirs...)
+				pending += len(dirs)
+			}
+			pending--
+			ready.Broadcast()
+			mu.Unlock()
 		}
 	}
 
-	// If we get nothing back, that means 'prefix' does not exist
-	if len(items) == 0 {
-		return items, fs.ErrNotExist
+	for i := 0; i < s.listConcurrency(); i++ {
+		wg.Add(1)
+		go worker()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
 	return items, nil
 }
 
-func (s *Storage) Stat(_ context.Context, key string) (certmagic.KeyInfo, error) {
-	s.logger.Debugw("Stat() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)))
+// listDirectory makes a single LIST request for prefix, returning the full keys of all entries, and separately those
+// of the entries that are "directories".  A prefix that doesn't exist has neither.
+func (s *Storage) listDirectory(ctx context.Context, token, prefix string) ([]string, []string, error) {
+	// "/" lists the root like it does for FileStorage, and so do the keys below it, which are joined on to it
+	listPath := s.vaultMetadataPath(strings.TrimPrefix(prefix, "/"))
+	s.logger.Debugw("List() at url", "operation", "list", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), listPath))
 
-	// Get the secret
-	result := &response{}
+	result := &listResponse{}
 	errResponse := &errorResponse{}
-	resp, err := s.client.Get(s.getToken(), s.vaultDataPath(key), result, errResponse)
+	resp, err := s.client.List(ctx, token, listPath, result, errResponse)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
 		s.logger.Errorw(
-			"[ERROR] Unable to stat certificate",
-			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"[ERROR] Unable to list certificates",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), listPath),
 			"error", err.Error(),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
-		return certmagic.KeyInfo{}, err
+		return nil, nil, newVaultError(listPath, resp, errResponse, err)
 	}
 
 	if resp.IsError() && resp.StatusCode() != http.StatusNotFound {
 		s.logger.Errorw(
-			"[ERROR] Unable to stat certificate",
-			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"[ERROR] Unable to list certificates",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), listPath),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
+		return nil, nil, newVaultError(listPath, resp, errResponse, nil)
 	}
 
-	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
-		return certmagic.KeyInfo{}, fs.ErrNotExist
+	keys := make([]string, 0)
+	dirs := make([]string, 0)
+	for _, encoded := range result.Data.Keys {
+		entry, err := decodeKey(encoded)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if isChunksDirectory(entry) {
+			continue
+		}
+
+		name := strings.TrimSuffix(entry, "/")
+		keys = append(keys, path.Join(prefix, name))
+		if strings.HasSuffix(entry, "/") {
+			dirs = append(dirs, path.Join(prefix, name))
+		}
+	}
+
+	return keys, dirs, nil
+}
+
+// Stat describes key.  Modified is the last time the key was written (the updated_time of its kv-v2 metadata), and a
+// key that only exists as the prefix of other keys is reported as a "directory", with IsTerminal set to false.  The
+// size is taken from the key's custom_metadata when it was written there for the current version (see
+// CustomMetadataConfigInterface), so the value doesn't have to be read.  Without custom_metadata, which is off by
+// default, Stat reads the value as well and takes two requests instead of one: writing it costs an extra request on
+// every Store instead, so which is cheaper depends on how often keys are written compared to how often they're statted.
+func (s *Storage) Stat(ctx context.Context, key string) (info certmagic.KeyInfo, err error) {
+	ctx, end := s.startOperation(ctx, OperationStat, key)
+	defer end(&err)
+
+	if err := validateKey(key); err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+
+	s.logger.Debugw("Stat() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)))
+
+	meta, err := s.readMetadata(ctx, key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.statDirectory(ctx, key)
+	}
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+
+	// Soft-deleted and destroyed keys only exist as far as List is concerned
+	current, ok := meta.Data.Versions[Sprintf("%d", meta.Data.CurrentVersion)]
+	if !ok || current.Destroyed || current.deleted() {
+		return s.statDirectory(ctx, key)
+	}
+
+	size, err := s.statSize(ctx, key, meta)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
 	}
 
 	return certmagic.KeyInfo{
 		Key:        key,
 		IsTerminal: true,
-		Size:       int64(len(result.Data.Data.Certmagic.Data)),
-		Modified:   time.Time(result.Data.Metadata.CreatedTime),
+		Size:       size,
+		Modified:   time.Time(meta.Data.UpdatedTime),
 	}, nil
 }
 
-func (s *Storage) Lock(ctx context.Context, key string) error {
+// statDirectory describes key as a "directory" if any keys exist below it
+func (s *Storage) statDirectory(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	children, _, err := s.listDirectory(ctx, s.getToken(ctx), key)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+
+	if len(children) == 0 {
+		return certmagic.KeyInfo{}, fs.ErrNotExist
+	}
+
+	return certmagic.KeyInfo{Key: key, IsTerminal: false}, nil
+}
+
+// statSize returns the size of the value stored at key, preferably from its custom_metadata when that describes the
+// current version
+func (s *Storage) statSize(ctx context.Context, key string, meta *metadataResponse) (int64, error) {
+	if meta.Data.CustomMetadata["size_version"] == strconv.Itoa(meta.Data.CurrentVersion) {
+		if size, err := strconv.ParseInt(meta.Data.CustomMetadata["size"], 10, 64); err == nil {
+			return size, nil
+		}
+	}
+
+	result, err := s.loadVersion(ctx, key, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.valueSize(ctx, key, &result.Data.Data)
+}
+
+func (s *Storage) Lock(ctx context.Context, key string) (err error) {
+	start := time.Now()
+	ctx, end := s.startOperation(ctx, OperationLock, key)
+	defer end(&err)
+
+	version := 0
+	defer s.audit(ctx, OperationLock, key, &version, &err)
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
 	lock := Sprintf("%s.lock", key)
 	for {
 		// Get the secret
 		getResult := &response{}
 		errResponse := &errorResponse{}
-		resp, err := s.client.Get(s.getToken(), s.vaultDataPath(lock), getResult, errResponse)
+		resp, err := s.client.Get(ctx, s.getToken(ctx), s.vaultDataPath(lock), getResult, errResponse)
 		if err != nil {
 			s.logger.Errorw(
 				"[ERROR] Unable to get lock",
@@ -290,39 +580,59 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 				"error", err.Error(),
 				"vault_errors", s.vaultErrorString(errResponse),
 				"response_code", resp.StatusCode(),
-				"response_body", resp.String(),
+				"response_body", s.responseBody(resp),
+			)
+			return newVaultError(s.vaultDataPath(lock), resp, errResponse, err)
+		}
+
+		if resp.IsError() && resp.StatusCode() != http.StatusNotFound {
+			s.logger.Errorw(
+				"[ERROR] Unable to get lock",
+				"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(lock)),
+				"vault_errors", s.vaultErrorString(errResponse),
+				"response_code", resp.StatusCode(),
+				"response_body", s.responseBody(resp),
 			)
+			return newVaultError(s.vaultDataPath(lock), resp, errResponse, nil)
+		}
+
+		expires, err := decodeLock(&getResult.Data.Data)
+		if err != nil {
 			return err
 		}
 
 		// If lock doesn't exist break immediately to create a new one
-		if getResult.Data.Data.Certmagic.Lock == nil {
+		if expires == nil {
 			break
 		}
 
 		// Lock exists, check if expired or sleep 5 seconds and check again
-		if time.Now().After(time.Time(*getResult.Data.Data.Certmagic.Lock)) {
+		if time.Now().After(time.Time(*expires)) {
 			if err := s.Unlock(ctx, key); err != nil {
 				return err
 			}
+			s.metrics().IncLockSteals()
 			break
 		}
 
 		select {
 		case <-time.After(time.Duration(s.config.GetLockPollingInterval())):
 		case <-ctx.Done():
-			return ctx.Err()
+			return Errorf("%w: %w", ErrLockHeld, ctx.Err())
 		}
 	}
 
+	s.metrics().ObserveLockWait(time.Since(start))
+
 	// Lock doesn't exist, create it now
 	expiration := time.Now().Add(time.Duration(s.config.GetLockTimeout()))
 	secret := &certificateSecret{
-		Certmagic: certMagicCertificateSecret{Lock: (*Time)(&expiration)},
+		FormatVersion: currentFormatVersion,
+		Certmagic:     certMagicCertificateSecret{Lock: (*Time)(&expiration)},
 	}
-	result := &response{}
+	result := &writeResponse{}
 	errResponse := &errorResponse{}
-	resp, err := s.client.Post(s.getToken(), s.vaultDataPath(lock), secret, result, errResponse)
+	resp, err := s.client.Post(ctx, s.getToken(ctx), s.vaultDataPath(lock), secret, result, errResponse)
 	if err != nil {
 		s.logger.Errorw(
 			"[ERROR] Unable to create lock",
@@ -330,30 +640,43 @@ func (s *Storage) Lock(ctx context.Context, key string) error {
 			"error", err.Error(),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
-		return err
+		return newVaultError(s.vaultDataPath(lock), resp, errResponse, err)
 	}
 
 	if resp.IsError() {
 		s.logger.Errorw(
 			"[ERROR] Unable to create lock",
-			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(lock)),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
-		return errResponse.Error()
+		return newVaultError(s.vaultDataPath(lock), resp, errResponse, nil)
 	}
 
+	version = result.Data.Version
+	s.describeKey(ctx, lock, nil, result.Data.Version)
+
 	return nil
 }
 
-func (s *Storage) Unlock(_ context.Context, key string) error {
+func (s *Storage) Unlock(ctx context.Context, key string) (err error) {
+	ctx, end := s.startOperation(ctx, OperationUnlock, key)
+	defer end(&err)
+
+	version := 0
+	defer s.audit(ctx, OperationUnlock, key, &version, &err)
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
 	lock := Sprintf("%s.lock", key)
 	result := &response{}
 	errResponse := &errorResponse{}
-	resp, err := s.client.Delete(s.getToken(), s.vaultMetadataPath(lock), result, errResponse)
+	resp, err := s.client.Delete(ctx, s.getToken(ctx), s.vaultMetadataPath(lock), result, errResponse)
 	if err != nil {
 		s.logger.Errorw(
 			"[ERROR] Unable to remove lock",
@@ -361,9 +684,9 @@ func (s *Storage) Unlock(_ context.Context, key string) error {
 			"error", err.Error(),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
 		)
-		return err
+		return newVaultError(s.vaultMetadataPath(lock), resp, errResponse, err)
 	}
 
 	if resp.IsError() && resp.StatusCode() != http.StatusNotFound {
@@ -372,23 +695,69 @@ func (s *Storage) Unlock(_ context.Context, key string) error {
 			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
 			"vault_errors", s.vaultErrorString(errResponse),
 			"response_code", resp.StatusCode(),
-			"response_body", resp.String(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultMetadataPath(lock), resp, errResponse, nil)
+	}
+
+	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
+		return newVaultError(s.vaultMetadataPath(lock), resp, errResponse, nil)
+	}
+
+	return nil
+}
+
+// deleteMetadata permanently removes key and all of its versions
+func (s *Storage) deleteMetadata(ctx context.Context, key string) error {
+	s.logger.Debugw("deleteMetadata() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)))
+
+	result := &response{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.Delete(ctx, s.getToken(ctx), s.vaultMetadataPath(key), result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to delete certificate",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultMetadataPath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() && resp.StatusCode() != http.StatusNotFound {
+		s.logger.Errorw(
+			"[ERROR] Unable to delete certificate",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
 		)
+		return newVaultError(s.vaultMetadataPath(key), resp, errResponse, nil)
 	}
 
 	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
-		return fs.ErrNotExist
+		return newVaultError(s.vaultMetadataPath(key), resp, errResponse, nil)
 	}
 
 	return nil
 }
 
 func (s *Storage) vaultDataPath(key string) string {
-	return vaultCertMagicCertificateDataPathFormat.String(s.config.GetSecretsPath(), s.config.GetPathPrefix(), key)
+	return vaultCertMagicCertificateDataPathFormat.String(s.config.GetSecretsPath(), s.config.GetPathPrefix(), requestPath(encodeKey(key)))
 }
 
 func (s *Storage) vaultMetadataPath(key string) string {
-	return vaultCertMagicCertificateMetadataPathFormat.String(s.config.GetSecretsPath(), s.config.GetPathPrefix(), key)
+	return vaultCertMagicCertificateMetadataPathFormat.String(s.config.GetSecretsPath(), s.config.GetPathPrefix(), requestPath(encodeKey(key)))
+}
+
+func (s *Storage) vaultUndeletePath(key string) string {
+	return vaultCertMagicCertificateUndeletePathFormat.String(s.config.GetSecretsPath(), s.config.GetPathPrefix(), requestPath(encodeKey(key)))
+}
+
+func (s *Storage) vaultDestroyPath(key string) string {
+	return vaultCertMagicCertificateDestroyPathFormat.String(s.config.GetSecretsPath(), s.config.GetPathPrefix(), requestPath(encodeKey(key)))
 }
 
 func (s *Storage) vaultErrorString(resp *errorResponse) string {
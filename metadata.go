@@ -0,0 +1,189 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	. "fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// KeyMetadataConfigInterface can optionally be implemented by a StorageConfigInterface to control kv-v2 retention of
+// the keys written by Store.  The settings are written to the key's metadata when Store creates it, and to the chunk
+// keys of values split over several (see ChunkingConfigInterface); zero values are left out, so the mount defaults
+// apply.  Lock keys are never given retention settings.
+//
+// When GetCasRequired() is true, Store and Rollback write with check-and-set against the key's current version.
+type KeyMetadataConfigInterface interface {
+	GetMaxVersions() int
+	GetDeleteVersionAfter() Duration
+	GetCasRequired() bool
+}
+
+func (s *Storage) keyMetadataConfig() KeyMetadataConfigInterface {
+	if config, ok := s.config.(KeyMetadataConfigInterface); ok {
+		return config
+	}
+
+	return nil
+}
+
+// casVersion returns the version a check-and-set write to key has to be made against, or nil if check-and-set is not
+// configured.  A key that doesn't exist yet has to be written with version 0.
+func (s *Storage) casVersion(ctx context.Context, key string) (*int, error) {
+	config := s.keyMetadataConfig()
+	if config == nil || !config.GetCasRequired() {
+		return nil, nil
+	}
+
+	version := 0
+	meta, err := s.readMetadata(ctx, key)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	if meta != nil {
+		version = meta.Data.CurrentVersion
+	}
+
+	return &version, nil
+}
+
+// CustomMetadataConfigInterface can optionally be implemented by a StorageConfigInterface to have Store (and Lock)
+// describe each key in its kv-v2 custom_metadata: the kind of CertMagic key, the size of the value (which lets Stat
+// skip reading it) and, for certificates, the leaf's subject names, issuer, serial and validity.  This makes
+// certificates searchable from the Vault UI/CLI without reading any private material, at the cost of an extra request
+// per write.
+type CustomMetadataConfigInterface interface {
+	GetWriteCustomMetadata() bool
+}
+
+const (
+	// Vault's limits on custom_metadata values
+	customMetadataMaxValueLength = 512
+)
+
+// applyKeyMetadata writes the key's metadata after value has been written to it as 'version'.  Retention settings are
+// only applied when the key has just been created (version 1), so they can still be changed by hand later on, and
+// never to short-lived lock keys.  Chunk keys only get the retention settings, they aren't described in custom_metadata.
+func (s *Storage) applyKeyMetadata(ctx context.Context, key string, value []byte, version int) error {
+	input := &metadataInput{}
+
+	if config := s.keyMetadataConfig(); config != nil && version == 1 && keyTypeOf(key) != keyTypeLock {
+		input.MaxVersions = config.GetMaxVersions()
+		input.CasRequired = config.GetCasRequired()
+		if config.GetDeleteVersionAfter() > 0 {
+			input.DeleteVersionAfter = time.Duration(config.GetDeleteVersionAfter()).String()
+		}
+	}
+
+	if config, ok := s.config.(CustomMetadataConfigInterface); ok && config.GetWriteCustomMetadata() && keyTypeOf(key) != keyTypeChunk {
+		input.CustomMetadata = customMetadata(key, value, version)
+	}
+
+	if input.MaxVersions == 0 && input.DeleteVersionAfter == "" && !input.CasRequired && len(input.CustomMetadata) == 0 {
+		return nil
+	}
+
+	return s.writeMetadata(ctx, key, input)
+}
+
+// describeKey is applyKeyMetadata for writes that already succeeded, where failing to write the metadata is only
+// logged
+func (s *Storage) describeKey(ctx context.Context, key string, value []byte, version int) {
+	if err := s.applyKeyMetadata(ctx, key, value, version); err != nil {
+		s.logger.Warnw(
+			"Unable to write certificate metadata, the certificate itself was written",
+			"key", key,
+			"version", version,
+			"error", err.Error(),
+		)
+	}
+}
+
+// customMetadata describes key (and value, for certificates) as kv-v2 custom_metadata.  The size is written along with
+// the version it describes, since custom_metadata isn't versioned: it goes stale when the key is written without
+// updating it.
+func customMetadata(key string, value []byte, version int) map[string]string {
+	kind := keyTypeOf(key)
+	meta := map[string]string{"certmagic_type": string(kind)}
+	if kind != keyTypeLock {
+		meta["size"] = strconv.Itoa(len(value))
+		meta["size_version"] = strconv.Itoa(version)
+	}
+
+	if kind != keyTypeCertificate {
+		return meta
+	}
+
+	// CertMagic stores the full chain, the leaf comes first
+	block, _ := pem.Decode(value)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return meta
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return meta
+	}
+
+	names := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+
+	meta["subject"] = truncateCustomMetadata(cert.Subject.CommonName)
+	meta["names"] = truncateCustomMetadata(strings.Join(names, ","))
+	meta["issuer"] = truncateCustomMetadata(cert.Issuer.String())
+	meta["serial"] = cert.SerialNumber.Text(16)
+	meta["not_before"] = cert.NotBefore.UTC().Format(time.RFC3339)
+	meta["not_after"] = cert.NotAfter.UTC().Format(time.RFC3339)
+
+	return meta
+}
+
+func truncateCustomMetadata(value string) string {
+	if len(value) > customMetadataMaxValueLength {
+		return value[:customMetadataMaxValueLength]
+	}
+
+	return value
+}
+
+// writeMetadata updates the kv-v2 metadata of key.  Fields left empty in input are not changed by Vault.
+func (s *Storage) writeMetadata(ctx context.Context, key string, input *metadataInput) error {
+	s.logger.Debugw("writeMetadata() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)))
+
+	result := &response{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.PostRaw(ctx, s.getToken(ctx), s.vaultMetadataPath(key), input, result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to write certificate metadata",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)),
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultMetadataPath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to write certificate metadata",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return newVaultError(s.vaultMetadataPath(key), resp, errResponse, nil)
+	}
+
+	return nil
+}
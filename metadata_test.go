@@ -0,0 +1,35 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"testing"
+)
+
+// TestMetadataErrorsAreLogged checks that Store and Lock succeed when the key's metadata can't be written, but say so
+func TestMetadataErrorsAreLogged(t *testing.T) {
+	ctx := context.Background()
+	core, logs := observer.New(zapcore.WarnLevel)
+	storage, vault := newTestStorage(t, &Config{WriteCustomMetadata: true, Logger: zap.New(core).Sugar()})
+	vault.deny = func(method, kind, _ string, _ bool) bool { return method == http.MethodPost && kind == "metadata" }
+
+	if err := storage.Store(ctx, "certificates/example.com/example.com.crt", []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Lock(ctx, "example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := logs.FilterLevelExact(zapcore.WarnLevel).FilterMessageSnippet("Unable to write certificate metadata").All()
+	if len(warnings) != 2 {
+		t.Fatalf("logged %d metadata warnings, want 2: %v", len(warnings), logs.All())
+	}
+	for i, key := range []string{"certificates/example.com/example.com.crt", "example.com.lock"} {
+		if warnings[i].ContextMap()["key"] != key {
+			t.Errorf("warning %d is about %v, want %s", i, warnings[i].ContextMap()["key"], key)
+		}
+	}
+}
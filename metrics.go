@@ -0,0 +1,139 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"time"
+)
+
+// Operation names a Storage operation reported to a MetricsCollector.
+type Operation string
+
+const (
+	OperationStore  Operation = "store"
+	OperationLoad   Operation = "load"
+	OperationDelete Operation = "delete"
+	OperationExists Operation = "exists"
+	OperationList   Operation = "list"
+	OperationStat   Operation = "stat"
+	OperationLock   Operation = "lock"
+	OperationUnlock Operation = "unlock"
+
+	OperationLoadVersion     Operation = "load_version"
+	OperationListVersions    Operation = "list_versions"
+	OperationRollback        Operation = "rollback"
+	OperationUndelete        Operation = "undelete"
+	OperationDestroyVersions Operation = "destroy_versions"
+	OperationMigrate         Operation = "migrate"
+	OperationRewrapTransit   Operation = "rewrap_transit"
+	OperationHealth          Operation = "health"
+	OperationValidate        Operation = "validate"
+)
+
+// ErrorClass is a low-cardinality classification of the error an operation returned, suitable as a metric label.
+type ErrorClass string
+
+const (
+	ErrorClassNone             ErrorClass = ""
+	ErrorClassInvalidKey       ErrorClass = "invalid_key"
+	ErrorClassCanceled         ErrorClass = "canceled"
+	ErrorClassDeadlineExceeded ErrorClass = "deadline_exceeded"
+	ErrorClassLockHeld         ErrorClass = "lock_held"
+	ErrorClassNotExist         ErrorClass = "not_exist"
+	ErrorClassCheckAndSet      ErrorClass = "check_and_set"
+	ErrorClassBadRequest       ErrorClass = "bad_request"
+	ErrorClassPermissionDenied ErrorClass = "permission_denied"
+	ErrorClassRateLimited      ErrorClass = "rate_limited"
+	ErrorClassSealed           ErrorClass = "sealed"
+	ErrorClassUnavailable      ErrorClass = "unavailable"
+	ErrorClassServerError      ErrorClass = "server_error"
+	ErrorClassUnreachable      ErrorClass = "unreachable"
+	ErrorClassCorrupted        ErrorClass = "corrupted"
+	ErrorClassEncryption       ErrorClass = "encryption"
+	ErrorClassCompression      ErrorClass = "compression"
+	ErrorClassUnsupported      ErrorClass = "unsupported_format"
+	ErrorClassOther            ErrorClass = "other"
+)
+
+// errorClasses is checked in order, so more specific errors must come before the ones they also match (a failed
+// check-and-set is a bad request too, a sealed Vault is unavailable too...).
+var errorClasses = []struct {
+	err   error
+	class ErrorClass
+}{
+	{ErrInvalidKey, ErrorClassInvalidKey},
+	{ErrLockHeld, ErrorClassLockHeld},
+	{context.Canceled, ErrorClassCanceled},
+	{context.DeadlineExceeded, ErrorClassDeadlineExceeded},
+	{fs.ErrNotExist, ErrorClassNotExist},
+	{ErrCheckAndSet, ErrorClassCheckAndSet},
+	{ErrBadRequest, ErrorClassBadRequest},
+	{ErrPermissionDenied, ErrorClassPermissionDenied},
+	{ErrRateLimited, ErrorClassRateLimited},
+	{ErrVaultSealed, ErrorClassSealed},
+	{ErrVaultUnavailable, ErrorClassUnavailable},
+	{ErrVaultServerError, ErrorClassServerError},
+	{ErrVaultUnreachable, ErrorClassUnreachable},
+	{ErrCorrupted, ErrorClassCorrupted},
+	{ErrEncryption, ErrorClassEncryption},
+	{ErrCompression, ErrorClassCompression},
+	{ErrUnsupportedFormat, ErrorClassUnsupported},
+}
+
+// ClassifyError returns the ErrorClass of err, ErrorClassNone if err is nil
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassNone
+	}
+
+	for _, candidate := range errorClasses {
+		if errors.Is(err, candidate.err) {
+			return candidate.class
+		}
+	}
+
+	return ErrorClassOther
+}
+
+// MetricsCollector receives measurements from Storage.  Implementations must be safe for concurrent use, and should
+// return quickly since they are called inline.  Adapting it to Prometheus, OpenTelemetry, expvar etc. is left to the
+// caller, so this package doesn't force a metrics library on anyone.
+type MetricsCollector interface {
+	// ObserveOperation is called once for every call of a Storage method named by an Operation
+	ObserveOperation(operation Operation, duration time.Duration, class ErrorClass)
+
+	// ObserveLockWait is called with the time Lock spent waiting for the lock to be released (or to expire) before
+	// trying to take it
+	ObserveLockWait(duration time.Duration)
+
+	// IncLockSteals is called when Lock removes an expired lock left by another holder
+	IncLockSteals()
+
+	// ObserveLogin is called for every approle login.  'renewal' is true when it replaced an expired token.
+	ObserveLogin(renewal bool, class ErrorClass)
+
+	// SetTokenTTL is called with the remaining lifetime of the approle token whenever it is used or renewed
+	SetTokenTTL(ttl time.Duration)
+}
+
+// MetricsConfigInterface can optionally be implemented by a StorageConfigInterface to collect metrics.
+type MetricsConfigInterface interface {
+	GetMetricsCollector() MetricsCollector
+}
+
+func (s *Storage) metrics() MetricsCollector {
+	if config, ok := s.config.(MetricsConfigInterface); ok && config.GetMetricsCollector() != nil {
+		return config.GetMetricsCollector()
+	}
+
+	return nopMetricsCollector{}
+}
+
+type nopMetricsCollector struct{}
+
+func (nopMetricsCollector) ObserveOperation(Operation, time.Duration, ErrorClass) {}
+func (nopMetricsCollector) ObserveLockWait(time.Duration)                         {}
+func (nopMetricsCollector) IncLockSteals()                                        {}
+func (nopMetricsCollector) ObserveLogin(bool, ErrorClass)                         {}
+func (nopMetricsCollector) SetTokenTTL(time.Duration)                             {}
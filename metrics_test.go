@@ -0,0 +1,82 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetricsCollector struct {
+	nopMetricsCollector
+
+	mu         sync.Mutex
+	operations []Operation
+}
+
+func (r *recordingMetricsCollector) ObserveOperation(operation Operation, _ time.Duration, _ ErrorClass) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.operations = append(r.operations, operation)
+}
+
+// take returns the operations observed since it was last called
+func (r *recordingMetricsCollector) take() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	taken := r.operations
+	r.operations = nil
+
+	return taken
+}
+
+// TestOperations checks that every exported operation is observed once, and nested ones only when called explicitly
+func TestOperations(t *testing.T) {
+	ctx := context.Background()
+	metrics := &recordingMetricsCollector{}
+	storage, _ := newTestStorage(t, &Config{MetricsCollector: metrics, MaxChunkSize: 64})
+
+	key := "certificates/example.com/example.com.json"
+	steps := []struct {
+		operation Operation
+		run       func() error
+	}{
+		{OperationStore, func() error { return storage.Store(ctx, key, []byte(strings.Repeat("x", 200))) }},
+		{OperationLoadVersion, func() error { _, err := storage.LoadVersion(ctx, key, 1); return err }},
+		{OperationListVersions, func() error { _, err := storage.ListVersions(ctx, key); return err }},
+		{OperationRollback, func() error { return storage.Rollback(ctx, key, 1) }},
+		{OperationUndelete, func() error { return storage.Undelete(ctx, key) }},
+		{OperationDestroyVersions, func() error { return storage.DestroyVersions(ctx, key, 1) }},
+		{OperationMigrate, func() error { _, err := storage.Migrate(ctx); return err }},
+		{OperationRewrapTransit, func() error { _, err := storage.RewrapTransit(ctx); return err }},
+		{OperationHealth, func() error { _, err := storage.Health(ctx); return err }},
+		{OperationDelete, func() error { return storage.Delete(ctx, key) }},
+	}
+
+	for _, step := range steps {
+		if err := step.run(); err != nil {
+			t.Fatalf("%s: %v", step.operation, err)
+		}
+
+		// Migrate and RewrapTransit walk the keys with List
+		want := []Operation{step.operation}
+		if step.operation == OperationMigrate || step.operation == OperationRewrapTransit {
+			want = []Operation{OperationList, step.operation}
+		}
+
+		got := metrics.take()
+		if len(got) != len(want) || got[len(got)-1] != step.operation || got[0] != want[0] {
+			t.Errorf("%s observed %q, want %q", step.operation, got, want)
+		}
+	}
+
+	if err := storage.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := metrics.take(); got[len(got)-1] != OperationValidate {
+		t.Errorf("Validate observed %q, want it last", got)
+	}
+}
@@ -0,0 +1,112 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+)
+
+// Migrate rewrites every record under the configured path prefix that was written with an older format_version in to
+// the current schema (and the configured StorageFormat).  Each rewrite is a new kv-v2 version, so the previous layout
+// can still be recovered with Rollback.  Lock keys are skipped since they are short-lived, and so are records that are
+// written concurrently, since they are then in the current format already.
+//
+// It returns the number of keys that were rewritten, which is also valid when an error is returned.
+func (s *Storage) Migrate(ctx context.Context) (migrated int, err error) {
+	ctx, end := s.startOperation(ctx, OperationMigrate, "")
+	defer end(&err)
+
+	err = s.walkRecords(ctx, func(key string, version int, secret *certificateSecret) error {
+		if secret.FormatVersion >= currentFormatVersion {
+			return nil
+		}
+
+		value, err := s.decodeValue(ctx, key, secret)
+		if err != nil {
+			return err
+		}
+
+		s.logger.Infow("Migrating certificate", "key", key, "format_version", secret.FormatVersion)
+		migratedSecret, err := s.encodeValue(ctx, key, value)
+		if err != nil {
+			return err
+		}
+
+		written, err := s.rewriteSecret(ctx, OperationMigrate, key, version, migratedSecret)
+		if err != nil {
+			return err
+		}
+		if written == 0 {
+			return nil
+		}
+		migrated++
+
+		s.describeKey(ctx, key, value, written)
+
+		return nil
+	})
+
+	return migrated, err
+}
+
+// walkRecords calls fn with the latest version of every record under the configured path prefix, and the number of
+// that version, skipping lock keys and keys that are deleted while walking.  It stops at the first error returned by
+// fn.
+func (s *Storage) walkRecords(ctx context.Context, fn func(key string, version int, secret *certificateSecret) error) error {
+	keys, err := s.List(ctx, "", true)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if keyTypeOf(key) == keyTypeLock {
+			continue
+		}
+
+		result, err := s.loadVersion(ctx, key, 0)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(key, result.Data.Metadata.Version, &result.Data.Data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rewriteSecret replaces 'version' of key, as read by walkRecords, with secret, auditing it as 'operation'.  It returns
+// the version written, or 0, leaving key alone, when another version was written since.
+func (s *Storage) rewriteSecret(ctx context.Context, operation Operation, key string, version int, secret *certificateSecret) (written int, err error) {
+	defer func() {
+		if written != 0 || err != nil {
+			s.audit(ctx, operation, key, &written, &err)
+		}
+	}()
+
+	if err := s.storeChunks(ctx, key, secret); err != nil {
+		return 0, err
+	}
+
+	result, err := s.writeSecretCAS(ctx, key, secret, &version)
+	if errors.Is(err, ErrCheckAndSet) {
+		s.logger.Infow("Skipping certificate changed concurrently", "key", key, "version", version)
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Data.Version, nil
+}
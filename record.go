@@ -0,0 +1,233 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	. "fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedFormat is returned when a secret was written with a newer format_version than this package can read.
+var ErrUnsupportedFormat = errors.New("unsupported record format")
+
+// currentFormatVersion is the schema version written by Store and Lock.  Bump it whenever the layout of
+// certificateSecret changes in a way older readers would misinterpret, and teach decodeValue/decodeLock about it.
+// Fields older readers can safely ignore, like 'certmagic.sha256', don't need a new version.
+//
+//	0: 'certmagic.data' (base64) and 'certmagic.lock', optionally the plain text fields of StorageFormatText
+//	1: same as 0, with 'format_version' and 'certmagic.size' (the size of the original value) set, and optionally:
+//	   'certmagic.encryption': when set 'certmagic.data' holds the encrypted value, and 'certmagic.sha256' its checksum
+//	   'certmagic.compression': when set the value was compressed (before encrypting it)
+//	   'certmagic.chunks': when set the payload is stored in chunk keys instead of 'certmagic.data'
+const currentFormatVersion = 1
+
+// StorageFormat selects how Store lays values out in the Vault secret.
+type StorageFormat string
+
+const (
+	// StorageFormatBase64 stores every value base64 encoded in 'certmagic.data' (the default, and the original format)
+	StorageFormatBase64 StorageFormat = "base64"
+
+	// StorageFormatText stores certificates, private keys and JSON metadata as plain strings in the 'certificate',
+	// 'private_key' and 'metadata' fields of the secret, so they are readable from the Vault UI and by other Vault
+	// consumers.  Anything else (e.g. OCSP staples) is still stored base64 encoded.
+	StorageFormatText StorageFormat = "text"
+)
+
+// StorageFormatConfigInterface can optionally be implemented by a StorageConfigInterface to choose the StorageFormat
+// used by Store.  Load reads values written in either format, so it can be changed at any time.
+type StorageFormatConfigInterface interface {
+	GetStorageFormat() StorageFormat
+}
+
+func (s *Storage) storageFormat() StorageFormat {
+	if config, ok := s.config.(StorageFormatConfigInterface); ok && config.GetStorageFormat() != "" {
+		return config.GetStorageFormat()
+	}
+
+	return StorageFormatBase64
+}
+
+// encodeValue lays value out in a secret.  The value is compressed and/or encrypted as configured, and written in the
+// configured StorageFormat when it is neither (ciphertext and compressed data aren't text) and doesn't need chunking.
+// Encrypted values are checksummed as stored, so the digest of the plaintext isn't kept next to its ciphertext.
+func (s *Storage) encodeValue(ctx context.Context, key string, value []byte) (*certificateSecret, error) {
+	secret := &certificateSecret{FormatVersion: currentFormatVersion}
+	secret.Certmagic.Size = int64(len(value))
+	secret.Certmagic.SHA256 = checksum(value)
+
+	payload := value
+	if algorithm, threshold := s.compression(); algorithm != "" && len(value) >= threshold {
+		compressed, err := compressValue(algorithm, value)
+		if err != nil {
+			return nil, err
+		}
+
+		payload = compressed
+		secret.Certmagic.Compression = algorithm
+	}
+
+	if mount, name := s.transitKey(); name != "" {
+		ciphertext, envelope, err := s.transitEncrypt(ctx, mount, name, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		secret.Certmagic.Data = ciphertext
+		secret.Certmagic.Encryption = envelope
+		secret.Certmagic.SHA256 = checksum(ciphertext)
+		return secret, nil
+	}
+
+	if provider := s.keyProvider(); provider != nil {
+		ciphertext, envelope, err := s.encryptValue(ctx, provider, key, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		secret.Certmagic.Data = ciphertext
+		secret.Certmagic.Encryption = envelope
+		secret.Certmagic.SHA256 = checksum(ciphertext)
+		return secret, nil
+	}
+
+	if secret.Certmagic.Compression != "" || s.storageFormat() != StorageFormatText || !utf8.Valid(value) || s.needsChunks(len(value)) {
+		secret.Certmagic.Data = payload
+		return secret, nil
+	}
+
+	switch keyTypeOf(key) {
+	case keyTypeCertificate:
+		secret.Certificate = string(value)
+	case keyTypePrivateKey:
+		secret.PrivateKey = string(value)
+	case keyTypeMetadata:
+		secret.Metadata = string(value)
+	case keyTypeAccount:
+		if isPrivateKeyPEM(value) {
+			secret.PrivateKey = string(value)
+		} else {
+			secret.Metadata = string(value)
+		}
+	default:
+		secret.Certmagic.Data = value
+	}
+
+	return secret, nil
+}
+
+// decodeValue reads the value of key back from secret and verifies its checksum, if the secret has one
+func (s *Storage) decodeValue(ctx context.Context, key string, secret *certificateSecret) ([]byte, error) {
+	value, err := s.decodeVersionedValue(ctx, key, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	// The stored payload has been verified before decrypting it
+	if checksumsPayload(secret) {
+		return value, nil
+	}
+
+	if err := verifyChecksum(key, secret.Certmagic.SHA256, value); err != nil {
+		s.logger.Errorw("[ERROR] Certificate failed checksum verification", "key", key, "error", err.Error())
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// decodeVersionedValue reads the value of key back from secret, dispatching on the format_version it was written with
+func (s *Storage) decodeVersionedValue(ctx context.Context, key string, secret *certificateSecret) ([]byte, error) {
+	switch secret.FormatVersion {
+	case 0, 1:
+		value := decodeValueV1(secret)
+		if len(secret.Certmagic.Chunks) > 0 {
+			chunks, err := s.loadChunks(ctx, key, secret.Certmagic.Chunks)
+			if err != nil {
+				return nil, err
+			}
+			value = chunks
+		}
+
+		if checksumsPayload(secret) {
+			if err := verifyChecksum(key, secret.Certmagic.SHA256, value); err != nil {
+				s.logger.Errorw("[ERROR] Certificate failed checksum verification", "key", key, "error", err.Error())
+				return nil, err
+			}
+		}
+
+		if secret.Certmagic.Encryption != nil {
+			decrypted, err := s.decryptValue(ctx, key, value, secret.Certmagic.Encryption)
+			if err != nil {
+				return nil, err
+			}
+			value = decrypted
+		}
+
+		if secret.Certmagic.Compression != "" {
+			return decompressValue(secret.Certmagic.Compression, value)
+		}
+		return value, nil
+	}
+
+	return nil, Errorf("%w: format_version %d", ErrUnsupportedFormat, secret.FormatVersion)
+}
+
+// valueSize returns the size of the (decompressed, decrypted) value stored in secret, without decoding it unless the
+// secret predates 'format_version'
+func (s *Storage) valueSize(ctx context.Context, key string, secret *certificateSecret) (int64, error) {
+	if secret.FormatVersion > 0 {
+		return secret.Certmagic.Size, nil
+	}
+
+	value, err := s.decodeValue(ctx, key, secret)
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(value)), nil
+}
+
+// checksumsPayload reports whether the checksum of secret covers its stored payload, rather than the decoded value
+func checksumsPayload(secret *certificateSecret) bool {
+	return secret.Certmagic.Encryption != nil
+}
+
+// hasValue reports whether secret holds a value, without decoding (and thus decrypting or reassembling) it
+func hasValue(secret *certificateSecret) bool {
+	return len(decodeValueV1(secret)) > 0 || len(secret.Certmagic.Chunks) > 0
+}
+
+// decodeLock reads the lock expiration from secret, or nil if it doesn't hold a lock
+func decodeLock(secret *certificateSecret) (*Time, error) {
+	switch secret.FormatVersion {
+	case 0, 1:
+		return secret.Certmagic.Lock, nil
+	}
+
+	return nil, Errorf("%w: format_version %d", ErrUnsupportedFormat, secret.FormatVersion)
+}
+
+// decodeValueV1 reads the value from a version 0/1 secret, whichever StorageFormat it was written with.  For encrypted
+// or compressed secrets this is the raw payload, which may still have to be decrypted and decompressed.
+func decodeValueV1(secret *certificateSecret) []byte {
+	switch {
+	case len(secret.Certmagic.Data) > 0:
+		return secret.Certmagic.Data
+	case secret.Certificate != "":
+		return []byte(secret.Certificate)
+	case secret.PrivateKey != "":
+		return []byte(secret.PrivateKey)
+	case secret.Metadata != "":
+		return []byte(secret.Metadata)
+	}
+
+	return nil
+}
+
+func isPrivateKeyPEM(value []byte) bool {
+	block, _ := pem.Decode(value)
+	return block != nil && strings.HasSuffix(block.Type, "PRIVATE KEY")
+}
@@ -0,0 +1,95 @@
+package certmagic_vault_storage
+
+import (
+	"encoding/json"
+	. "fmt"
+	"gopkg.in/resty.v1"
+)
+
+// redacted replaces the value of every redactedFields entry in a logged response body
+const redacted = "[REDACTED]"
+
+// redactedFields are the (case-sensitive) JSON fields that may hold certificates, private keys, tokens or other
+// credentials in a Vault response.  They are redacted wherever they appear in the body.
+var redactedFields = map[string]bool{
+	"data":           true,
+	"client_token":   true,
+	"accessor":       true,
+	"secret_id":      true,
+	"role_id":        true,
+	"id":             true,
+	"token":          true,
+	"certificate":    true,
+	"private_key":    true,
+	"plaintext":      true,
+	"ciphertext":     true,
+	"wrapped_key":    true,
+	"wrapping_token": true,
+}
+
+// UnsafeDebugConfigInterface can optionally be implemented by a StorageConfigInterface to log Vault response bodies
+// unredacted.  Those bodies can contain private keys and Vault tokens, so this is only meant for local troubleshooting.
+type UnsafeDebugConfigInterface interface {
+	GetUnsafeDebug() bool
+}
+
+func (s *Storage) unsafeDebug() bool {
+	if config, ok := s.config.(UnsafeDebugConfigInterface); ok {
+		return config.GetUnsafeDebug()
+	}
+
+	return false
+}
+
+// responseBody returns the body of resp as it may be logged, with secret material redacted unless unsafe debugging is
+// enabled
+func (s *Storage) responseBody(resp *resty.Response) string {
+	if resp == nil {
+		return ""
+	}
+
+	if s.unsafeDebug() {
+		return resp.String()
+	}
+
+	return redactBody(resp.Body())
+}
+
+// redactBody redacts redactedFields from a JSON body.  Bodies that aren't JSON can't be redacted selectively, so only
+// their size is returned.
+func redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return Sprintf("[REDACTED %d bytes]", len(body))
+	}
+
+	encoded, err := json.Marshal(redactValue(decoded))
+	if err != nil {
+		return Sprintf("[REDACTED %d bytes]", len(body))
+	}
+
+	return string(encoded)
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for field, nested := range v {
+			if redactedFields[field] && nested != nil {
+				v[field] = redacted
+			} else {
+				v[field] = redactValue(nested)
+			}
+		}
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = redactValue(nested)
+		}
+	}
+
+	return value
+}
@@ -3,15 +3,18 @@ package certmagic_vault_storage
 import (
 	. "fmt"
 	"strings"
+	"time"
 )
 
 const (
-	// vaultCertMagicCertificateDataPathFormat & vaultCertMagicCertificateMetadataPathFormat formatters are:
+	// vaultCertMagicCertificate*PathFormat formatters are:
 	//    1st %s: SecretsPath
 	//    2nd %s: PathPrefix
 	//    3rd %s: key/prefix
 	vaultCertMagicCertificateDataPathFormat     secretPathFormatType = "%s/data/%s/%s"
 	vaultCertMagicCertificateMetadataPathFormat secretPathFormatType = "%s/metadata/%s/%s"
+	vaultCertMagicCertificateUndeletePathFormat secretPathFormatType = "%s/undelete/%s/%s"
+	vaultCertMagicCertificateDestroyPathFormat  secretPathFormatType = "%s/destroy/%s/%s"
 )
 
 type secretPathFormatType string
@@ -30,20 +33,71 @@ type data struct {
 }
 
 type certificateSecret struct {
-	Certmagic certMagicCertificateSecret `json:"certmagic"`
+	// FormatVersion is the schema version of the secret, see currentFormatVersion.  Secrets written before it existed
+	// don't have it, and read as version 0.
+	FormatVersion int                        `json:"format_version,omitempty"`
+	Certmagic     certMagicCertificateSecret `json:"certmagic"`
+
+	// Plain text fields used by StorageFormatText, see encodeValue()
+	Certificate string `json:"certificate,omitempty"`
+	PrivateKey  string `json:"private_key,omitempty"`
+	Metadata    string `json:"metadata,omitempty"`
 }
 
 type certMagicCertificateSecret struct {
-	Data []byte `json:"data,omitempty"`
-	Lock *Time  `json:"lock,omitempty"`
+	Data        []byte              `json:"data,omitempty"`
+	Lock        *Time               `json:"lock,omitempty"`
+	Encryption  *encryptionEnvelope `json:"encryption,omitempty"`
+	Compression Compression         `json:"compression,omitempty"`
+	Size        int64               `json:"size,omitempty"`
+	SHA256      string              `json:"sha256,omitempty"`
+	Chunks      []int               `json:"chunks,omitempty"`
+}
+
+type encryptionEnvelope struct {
+	Scheme     string `json:"scheme"`
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key,omitempty"`
 }
 
 type metadata struct {
+	Version      int  `json:"version"`
 	Destroyed    bool `json:"destroyed"`
 	CreatedTime  Time `json:"created_time"`
 	DeletionTime Time `json:"deletion_time"`
 }
 
+// deleted reports whether the version has been (soft) deleted.  Keys with a delete_version_after get a deletion_time
+// in the future on every version as it is written, so a version is only deleted once its deletion_time has passed.
+func (m metadata) deleted() bool {
+	deletion := time.Time(m.DeletionTime)
+	return !deletion.IsZero() && !deletion.After(time.Now())
+}
+
+type writeResponse struct {
+	Data metadata `json:"data"`
+}
+
+type metadataInput struct {
+	MaxVersions        int               `json:"max_versions,omitempty"`
+	DeleteVersionAfter string            `json:"delete_version_after,omitempty"`
+	CasRequired        bool              `json:"cas_required,omitempty"`
+	CustomMetadata     map[string]string `json:"custom_metadata,omitempty"`
+}
+
+type metadataResponse struct {
+	Data metadataResponseData `json:"data"`
+}
+
+type metadataResponseData struct {
+	CurrentVersion int                 `json:"current_version"`
+	OldestVersion  int                 `json:"oldest_version"`
+	CreatedTime    Time                `json:"created_time"`
+	UpdatedTime    Time                `json:"updated_time"`
+	Versions       map[string]metadata `json:"versions"`
+	CustomMetadata map[string]string   `json:"custom_metadata"`
+}
+
 type listResponse struct {
 	Data listResponseData `json:"data"`
 }
@@ -51,3 +105,7 @@ type listResponse struct {
 type listResponseData struct {
 	Keys []string `json:"keys"`
 }
+
+type versionsInput struct {
+	Versions []int `json:"versions"`
+}
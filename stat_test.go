@@ -0,0 +1,85 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStatSize(t *testing.T) {
+	ctx := context.Background()
+	config := &Config{WriteCustomMetadata: true}
+	storage, vault := newTestStorage(t, config)
+
+	key := "certificates/example.com/example.com.json"
+	statSize := func(want int64) {
+		t.Helper()
+
+		info, err := storage.Stat(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size != want {
+			t.Errorf("Stat(%q).Size = %d, want %d", key, info.Size, want)
+		}
+	}
+
+	if err := storage.Store(ctx, key, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	statSize(5)
+
+	// The size in custom_metadata is now stale
+	config.WriteCustomMetadata = false
+	if err := storage.Store(ctx, key, []byte("second value")); err != nil {
+		t.Fatal(err)
+	}
+	statSize(12)
+
+	config.WriteCustomMetadata = true
+	if err := storage.Rollback(ctx, key, 1); err != nil {
+		t.Fatal(err)
+	}
+	statSize(5)
+
+	if custom := vault.CustomMetadata("certmagic/" + key); custom["size"] != "5" || custom["size_version"] != "3" {
+		t.Errorf("custom_metadata after Rollback = %v, want the size of version 3", custom)
+	}
+}
+
+// TestDeleteVersionAfter checks that the deletion_time Vault gives every version of a key with a delete_version_after
+// isn't mistaken for the version having been deleted
+func TestDeleteVersionAfter(t *testing.T) {
+	ctx := context.Background()
+	storage, vault := newTestStorage(t, &Config{DeleteVersionAfter: Duration(time.Hour)})
+
+	// The setting is applied after the first version is written, so only later versions get a deletion_time
+	key := "certificates/example.com/example.com.crt"
+	for _, value := range []string{"first", "second"} {
+		if err := storage.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := storage.Stat(ctx, key)
+	if err != nil || !info.IsTerminal || info.Size != int64(len("second")) {
+		t.Errorf("Stat(%q) = %+v, %v", key, info, err)
+	}
+
+	versions, err := storage.ListVersions(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, version := range versions {
+		if !version.Deleted.IsZero() {
+			t.Errorf("ListVersions(%q) reports version %d deleted at %s", key, version.Version, version.Deleted)
+		}
+	}
+
+	if err := storage.Undelete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if undeletes := vault.Requests("POST secret/undelete/certmagic/" + key); undeletes != 0 {
+		t.Errorf("Undelete() of a live key posted %d undeletes, want 0", undeletes)
+	}
+}
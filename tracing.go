@@ -0,0 +1,58 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+// tracerName is the instrumentation scope of the spans started by Storage and its Vault client
+const tracerName = "github.com/mywordpress-io/certmagic-vault-storage"
+
+// TracingConfigInterface can optionally be implemented by a StorageConfigInterface to have Storage emit OpenTelemetry
+// spans for its operations, and for every request made to Vault.  Spans are children of the span in the context passed
+// to the operation.  No spans are recorded by default.
+//
+// Request spans carry the Vault path and the HTTP status code, but no retry count: the Vault client makes a single
+// attempt per request, retrying is left to the caller.
+type TracingConfigInterface interface {
+	GetTracerProvider() trace.TracerProvider
+}
+
+func (s *Storage) tracerProvider() trace.TracerProvider {
+	if config, ok := s.config.(TracingConfigInterface); ok && config.GetTracerProvider() != nil {
+		return config.GetTracerProvider()
+	}
+
+	return trace.NewNoopTracerProvider()
+}
+
+// startOperation starts the span of a Storage operation.  The returned func is meant to be deferred with a pointer to
+// the operation's named error result: it ends the span and reports the operation to the MetricsCollector.
+func (s *Storage) startOperation(ctx context.Context, operation Operation, key string) (context.Context, func(*error)) {
+	start := time.Now()
+	ctx, span := s.tracer.Start(ctx, "storage."+string(operation),
+		trace.WithAttributes(
+			attribute.String("vault.operation", string(operation)),
+			attribute.String("vault.key", key),
+		),
+	)
+
+	return ctx, func(err *error) {
+		class := ClassifyError(*err)
+		s.metrics().ObserveOperation(operation, time.Since(start), class)
+
+		// A missing key is an answer rather than a failure, CertMagic relies on it
+		if class != ErrorClassNone {
+			span.SetAttributes(attribute.String("vault.error_class", string(class)))
+		}
+		if class != ErrorClassNone && class != ErrorClassNotExist {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+
+		span.End()
+	}
+}
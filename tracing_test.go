@@ -0,0 +1,88 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io/fs"
+	"testing"
+)
+
+// TestSpans checks the spans of an operation and of the Vault requests it makes, and that they join the caller's trace
+func TestSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	storage, _ := newTestStorage(t, &Config{TracerProvider: provider})
+
+	ctx, caller := provider.Tracer("test").Start(context.Background(), "caller")
+	key := "certificates/example.com/example.com.crt"
+	if err := storage.Store(ctx, key, []byte("certificate")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Load(ctx, "missing.crt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Load() of a missing key = %v", err)
+	}
+	caller.End()
+
+	var operations []sdktrace.ReadOnlySpan
+	requests := map[string][]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		switch span.Parent().SpanID() {
+		case caller.SpanContext().SpanID():
+			operations = append(operations, span)
+		default:
+			requests[span.Parent().SpanID().String()] = append(requests[span.Parent().SpanID().String()], span)
+		}
+	}
+	if len(operations) != 2 {
+		t.Fatalf("recorded %d operation spans below the caller, want 2", len(operations))
+	}
+
+	tests := []struct {
+		name       string
+		key        string
+		path       string
+		statusCode int64
+	}{
+		{name: "storage.store", key: key, path: "secret/data/certmagic/" + key, statusCode: 200},
+		{name: "storage.load", key: "missing.crt", path: "secret/data/certmagic/missing.crt", statusCode: 404},
+	}
+
+	for i, test := range tests {
+		operation := operations[i]
+		if operation.Name() != test.name {
+			t.Errorf("operation span %d is %q, want %q", i, operation.Name(), test.name)
+			continue
+		}
+
+		attributes := spanAttributes(operation)
+		if attributes["vault.operation"] != test.name[len("storage."):] || attributes["vault.key"] != test.key {
+			t.Errorf("%s has attributes %v", test.name, attributes)
+		}
+
+		found := false
+		for _, request := range requests[operation.SpanContext().SpanID().String()] {
+			attributes := spanAttributes(request)
+			if attributes["vault.path"] == test.path {
+				found = true
+				if attributes["http.status_code"] != test.statusCode {
+					t.Errorf("request span %q below %s has attributes %v", request.Name(), test.name, attributes)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no request span for %s below %s", test.path, test.name)
+		}
+	}
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]interface{} {
+	attributes := map[attribute.Key]interface{}{}
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value.AsInterface()
+	}
+
+	return attributes
+}
@@ -0,0 +1,191 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	. "fmt"
+	"strings"
+)
+
+const (
+	// encryptionSchemeTransit encrypts values with Vault's Transit engine.  The envelope's KeyID is the Transit mount
+	// and key name ("<mount>/<name>"), and 'certmagic.data' holds the Transit ciphertext ("vault:v1:...").
+	encryptionSchemeTransit = "vault-transit"
+
+	defaultTransitMountPath = "transit"
+
+	// vaultTransit*PathFormat formatters are:
+	//    1st %s: Transit mount path
+	//    2nd %s: Transit key name
+	vaultTransitEncryptPathFormat = "%s/encrypt/%s"
+	vaultTransitDecryptPathFormat = "%s/decrypt/%s"
+	vaultTransitRewrapPathFormat  = "%s/rewrap/%s"
+)
+
+// TransitConfigInterface can optionally be implemented by a StorageConfigInterface to encrypt values with a key in
+// Vault's Transit engine before they are written to the kv-v2 engine, so reading private keys also requires access
+// to the (separately permissioned) Transit key.  GetTransitMountPath() defaults to "transit".
+//
+// Transit takes precedence over EncryptionConfigInterface when both are configured.  After rotating the Transit key,
+// RewrapTransit re-encrypts existing records with its latest version.
+type TransitConfigInterface interface {
+	GetTransitMountPath() string
+	GetTransitKeyName() string
+}
+
+// transitKey returns the configured Transit mount path and key name, or empty strings if Transit isn't configured
+func (s *Storage) transitKey() (string, string) {
+	config, ok := s.config.(TransitConfigInterface)
+	if !ok || config.GetTransitKeyName() == "" {
+		return "", ""
+	}
+
+	mount := strings.Trim(config.GetTransitMountPath(), "/")
+	if mount == "" {
+		mount = defaultTransitMountPath
+	}
+
+	return mount, config.GetTransitKeyName()
+}
+
+type transitData struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+}
+
+type transitResponse struct {
+	Data transitData `json:"data"`
+}
+
+// transitEncrypt encrypts value with the Transit key 'name' at 'mount'
+func (s *Storage) transitEncrypt(ctx context.Context, mount, name string, value []byte) ([]byte, *encryptionEnvelope, error) {
+	result, err := s.transitRequest(ctx, Sprintf(vaultTransitEncryptPathFormat, mount, name), &transitData{Plaintext: value})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	envelope := &encryptionEnvelope{Scheme: encryptionSchemeTransit, KeyID: Sprintf("%s/%s", mount, name)}
+	return []byte(result.Data.Ciphertext), envelope, nil
+}
+
+// transitDecrypt is the inverse of transitEncrypt
+func (s *Storage) transitDecrypt(ctx context.Context, ciphertext []byte, envelope *encryptionEnvelope) ([]byte, error) {
+	mount, name, err := splitTransitKeyID(envelope.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.transitRequest(ctx, Sprintf(vaultTransitDecryptPathFormat, mount, name), &transitData{Ciphertext: string(ciphertext)})
+	if err != nil {
+		return nil, err
+	}
+
+	return result.Data.Plaintext, nil
+}
+
+// RewrapTransit re-encrypts every Transit encrypted record under the configured path prefix with the latest version
+// of its Transit key, without the plaintext ever leaving Vault.  Rewrapping always yields a new ciphertext (with a new
+// nonce), so records are only rewritten when the key version in the ciphertext's "vault:v<N>:" prefix changed.  It
+// returns the number of keys that were rewritten, which is also valid when an error is returned.
+func (s *Storage) RewrapTransit(ctx context.Context) (rewrapped int, err error) {
+	ctx, end := s.startOperation(ctx, OperationRewrapTransit, "")
+	defer end(&err)
+
+	err = s.walkRecords(ctx, func(key string, version int, secret *certificateSecret) error {
+		envelope := secret.Certmagic.Encryption
+		if envelope == nil || envelope.Scheme != encryptionSchemeTransit {
+			return nil
+		}
+
+		mount, name, err := splitTransitKeyID(envelope.KeyID)
+		if err != nil {
+			return err
+		}
+
+		payload := secret.Certmagic.Data
+		if len(secret.Certmagic.Chunks) > 0 {
+			payload, err = s.loadChunks(ctx, key, secret.Certmagic.Chunks)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := s.transitRequest(ctx, Sprintf(vaultTransitRewrapPathFormat, mount, name), &transitData{Ciphertext: string(payload)})
+		if err != nil {
+			return err
+		}
+
+		ciphertext := []byte(result.Data.Ciphertext)
+		if transitKeyVersion(string(ciphertext)) == transitKeyVersion(string(payload)) {
+			return nil
+		}
+
+		s.logger.Infow("Rewrapping certificate", "key", key, "transit_key", envelope.KeyID)
+		secret.Certmagic.Data = ciphertext
+		secret.Certmagic.SHA256 = checksum(ciphertext)
+		secret.Certmagic.Chunks = nil
+		written, err := s.rewriteSecret(ctx, OperationRewrapTransit, key, version, secret)
+		if err != nil {
+			return err
+		}
+		if written == 0 {
+			return nil
+		}
+		rewrapped++
+
+		return nil
+	})
+
+	return rewrapped, err
+}
+
+func (s *Storage) transitRequest(ctx context.Context, path string, input *transitData) (*transitResponse, error) {
+	s.logger.Debugw("transitRequest() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), path))
+
+	result := &transitResponse{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.PostRaw(ctx, s.getToken(ctx), path, input, result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to call transit engine",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), path),
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, Errorf("%w: %w", ErrEncryption, newVaultError(path, resp, errResponse, err))
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to call transit engine",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), path),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, Errorf("%w: %w", ErrEncryption, newVaultError(path, resp, errResponse, nil))
+	}
+
+	return result, nil
+}
+
+// transitKeyVersion returns the "vault:v<N>" prefix of a Transit ciphertext, naming the key version it was encrypted
+// with
+func transitKeyVersion(ciphertext string) string {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) < 3 {
+		return ""
+	}
+
+	return parts[0] + ":" + parts[1]
+}
+
+func splitTransitKeyID(keyID string) (string, string, error) {
+	i := strings.LastIndex(keyID, "/")
+	if i <= 0 || i == len(keyID)-1 {
+		return "", "", Errorf("%w: invalid transit key %q", ErrEncryption, keyID)
+	}
+
+	return keyID[:i], keyID[i+1:], nil
+}
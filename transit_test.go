@@ -0,0 +1,61 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRewrapTransit(t *testing.T) {
+	ctx := context.Background()
+	storage, vault := newTestStorage(t, &Config{TransitKeyName: "certs", MaxChunkSize: 256})
+
+	values := map[string]string{
+		"certificates/example.com/example.com.key":  "key",
+		"certificates/example.com/example.com.json": strings.Repeat("x", 1000),
+	}
+	for key, value := range values {
+		if err := storage.Store(ctx, key, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions := func() map[string]int {
+		counts := map[string]int{}
+		for key := range values {
+			counts[key] = vault.Versions("certmagic/" + key)
+		}
+		return counts
+	}
+
+	before := versions()
+	if rewrapped, err := storage.RewrapTransit(ctx); err != nil || rewrapped != 0 {
+		t.Fatalf("RewrapTransit() without rotation = %d, %v, want 0", rewrapped, err)
+	}
+	for key, count := range versions() {
+		if count != before[key] {
+			t.Errorf("RewrapTransit() without rotation wrote %s", key)
+		}
+	}
+
+	vault.RotateTransit()
+	if rewrapped, err := storage.RewrapTransit(ctx); err != nil || rewrapped != len(values) {
+		t.Fatalf("RewrapTransit() after rotation = %d, %v, want %d", rewrapped, err, len(values))
+	}
+	for key, count := range versions() {
+		if count != before[key]+1 {
+			t.Errorf("RewrapTransit() after rotation left %s at %d versions, want %d", key, count, before[key]+1)
+		}
+	}
+
+	if rewrapped, err := storage.RewrapTransit(ctx); err != nil || rewrapped != 0 {
+		t.Fatalf("RewrapTransit() after rewrapping = %d, %v, want 0", rewrapped, err)
+	}
+
+	for key, value := range values {
+		loaded, err := storage.Load(ctx, key)
+		if err != nil || string(loaded) != value {
+			t.Errorf("Load(%q) after rewrapping = %q, %v", key, loaded, err)
+		}
+	}
+}
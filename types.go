@@ -47,6 +47,15 @@ func (d *Duration) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// MarshalText and UnmarshalText let text based encodings, such as YAML, use the same "1m30s" notation as JSON
+func (d *Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(*d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(data []byte) error {
+	return d.UnmarshalJSON(data)
+}
+
 func MustParseURL(rawUrl string) *URL {
 	parsedUrl, _ := ParseURL(rawUrl)
 	return parsedUrl
@@ -87,3 +96,17 @@ func (u *URL) UnmarshalJSON(data []byte) error {
 
 	return nil
 }
+
+func (u *URL) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *URL) UnmarshalText(data []byte) error {
+	parsedUrl, err := url.Parse(string(data))
+	if err != nil {
+		return err
+	}
+	u.URL = parsedUrl
+
+	return nil
+}
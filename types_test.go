@@ -0,0 +1,63 @@
+package certmagic_vault_storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDurationText(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Duration
+		invalid bool
+	}{
+		{text: "1m30s", want: Duration(90 * time.Second)},
+		{text: "250ms", want: Duration(250 * time.Millisecond)},
+		{text: "", want: 0},
+		{text: "90", invalid: true},
+		{text: "soon", invalid: true},
+	}
+
+	for _, test := range tests {
+		var got Duration
+		err := got.UnmarshalText([]byte(test.text))
+		if test.invalid {
+			if err == nil {
+				t.Errorf("UnmarshalText(%q) = %s, want an error", test.text, time.Duration(got))
+			}
+			continue
+		}
+		if err != nil || got != test.want {
+			t.Errorf("UnmarshalText(%q) = %s, %v, want %s", test.text, time.Duration(got), err, time.Duration(test.want))
+			continue
+		}
+
+		text, err := got.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var again Duration
+		if err := again.UnmarshalText(text); err != nil || again != got {
+			t.Errorf("UnmarshalText(MarshalText(%q)) = %s, %v", test.text, time.Duration(again), err)
+		}
+	}
+}
+
+func TestURLText(t *testing.T) {
+	var u URL
+	if err := u.UnmarshalText([]byte("https://vault.example.com:8200/")); err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "https" || u.Host != "vault.example.com:8200" || u.Path != "/" {
+		t.Errorf("UnmarshalText() = %#v", u.URL)
+	}
+
+	text, err := u.MarshalText()
+	if err != nil || string(text) != "https://vault.example.com:8200/" {
+		t.Errorf("MarshalText() = %q, %v", text, err)
+	}
+
+	if err := u.UnmarshalText([]byte("https://vault example.com")); err == nil {
+		t.Errorf("UnmarshalText() of an invalid URL = %v, want an error", u.URL)
+	}
+}
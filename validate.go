@@ -0,0 +1,191 @@
+package certmagic_vault_storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	. "fmt"
+	"net/http"
+	"path"
+)
+
+// validationDirectory holds the canary keys written by Validate, below the configured path prefix
+const validationDirectory = "certmagic-vault-storage-validate"
+
+// ValidationError is returned by Validate for the first step that failed.  When it failed because Vault denied a
+// request, Capability and Path tell exactly which capability the token's policies are missing.
+type ValidationError struct {
+	// Step is the operation that failed: "store", "update", "metadata", "load", "exists", "stat", "list", "lock",
+	// "unlock" or "delete"
+	Step string
+
+	// Capability and Path are set when Vault answered with "permission denied"
+	Capability string
+	Path       string
+
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Capability != "" {
+		return Sprintf("validation failed at %s: missing %q capability on %s: %v", e.Step, e.Capability, e.Path, e.Err)
+	}
+
+	return Sprintf("validation failed at %s: %v", e.Step, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks that the configured token can do everything CertMagic needs, by storing (then overwriting), loading,
+// checking, describing, listing, locking, unlocking and deleting a canary key below the configured path prefix.  This
+// catches policy mistakes at startup, rather than at the first renewal.  The canary is removed again, even when a step
+// fails.
+func (s *Storage) Validate(ctx context.Context) (err error) {
+	ctx, end := s.startOperation(ctx, OperationValidate, "")
+	defer end(&err)
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+
+	key := path.Join(validationDirectory, hex.EncodeToString(suffix))
+	value := []byte("certmagic-vault-storage canary")
+	s.logger.Infow("Validating vault storage", "key", key)
+
+	stored := false
+	locked := false
+	defer func() {
+		if err == nil {
+			return
+		}
+		if locked {
+			_ = s.Unlock(ctx, key)
+		}
+		if stored {
+			_ = s.Delete(ctx, key)
+			_ = s.deleteMetadata(ctx, key)
+		}
+	}()
+
+	if err := s.Store(ctx, key, value); err != nil {
+		return validationError("store", err)
+	}
+	stored = true
+
+	// Renewals overwrite existing keys, which takes the "update" capability rather than "create"
+	if err := s.Store(ctx, key, value); err != nil {
+		return validationError("update", err)
+	}
+
+	// Store doesn't fail when the key's metadata can't be written, so do it again to find out
+	meta, err := s.readMetadata(ctx, key)
+	if err != nil {
+		return validationError("stat", err)
+	}
+	if err := s.applyKeyMetadata(ctx, key, value, meta.Data.CurrentVersion); err != nil {
+		return validationError("metadata", err)
+	}
+
+	loaded, err := s.Load(ctx, key)
+	if err != nil {
+		return validationError("load", err)
+	}
+	if !bytes.Equal(loaded, value) {
+		return validationError("load", Errorf("%w: canary read back differently than it was written", ErrCorrupted))
+	}
+
+	exists, err := s.ExistsE(ctx, key)
+	if err != nil {
+		return validationError("exists", err)
+	}
+	if !exists {
+		return validationError("exists", Errorf("canary %s is reported as missing", key))
+	}
+
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return validationError("stat", err)
+	}
+	if !info.IsTerminal || info.Size != int64(len(value)) {
+		return validationError("stat", Errorf("%w: canary is described as %+v", ErrCorrupted, info))
+	}
+
+	keys, err := s.List(ctx, validationDirectory, false)
+	if err != nil {
+		return validationError("list", err)
+	}
+	if !containsString(keys, key) {
+		return validationError("list", Errorf("canary %s is missing from the listing", key))
+	}
+
+	if err := s.Lock(ctx, key); err != nil {
+		return validationError("lock", err)
+	}
+	locked = true
+
+	if err := s.Unlock(ctx, key); err != nil {
+		return validationError("unlock", err)
+	}
+	locked = false
+
+	if err := s.Delete(ctx, key); err != nil {
+		return validationError("delete", err)
+	}
+	stored = false
+
+	// Soft-deleted and destroyed canaries keep their metadata around, and would show up in listings forever
+	if s.deleteMode() != DeleteModeMetadata {
+		_ = s.deleteMetadata(ctx, key)
+	}
+
+	return nil
+}
+
+func validationError(step string, err error) error {
+	validationErr := &ValidationError{Step: step, Err: err}
+
+	var vaultErr *VaultError
+	if errors.As(err, &vaultErr) && errors.Is(vaultErr, ErrPermissionDenied) {
+		validationErr.Capability = capabilityFor(step, vaultErr.Method)
+		validationErr.Path = vaultErr.Path
+	}
+
+	return validationErr
+}
+
+// capabilityFor returns the ACL capability Vault requires for a request made with 'method' by a step of Validate.  The
+// "store" and "lock" steps write new keys, so they need "create", the other steps write to existing ones.
+func capabilityFor(step, method string) string {
+	switch method {
+	case http.MethodGet:
+		return "read"
+	case "LIST":
+		return "list"
+	case http.MethodPost, http.MethodPut:
+		if step == "store" || step == "lock" {
+			return "create"
+		}
+		return "update"
+	case http.MethodPatch:
+		return "patch"
+	case http.MethodDelete:
+		return "delete"
+	}
+
+	return ""
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,80 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name       string
+		deny       func(method, kind, path string, exists bool) bool
+		step       string
+		capability string
+	}{
+		{name: "allowed"},
+		{
+			name: "create",
+			deny: func(method, kind, _ string, exists bool) bool {
+				return method == http.MethodPost && kind == "data" && !exists
+			},
+			step:       "store",
+			capability: "create",
+		},
+		{
+			name: "update",
+			deny: func(method, kind, _ string, exists bool) bool {
+				return method == http.MethodPost && kind == "data" && exists
+			},
+			step:       "update",
+			capability: "update",
+		},
+		{
+			name:       "metadata write",
+			deny:       func(method, kind, _ string, _ bool) bool { return method == http.MethodPost && kind == "metadata" },
+			step:       "metadata",
+			capability: "update",
+		},
+		{
+			name:       "metadata read",
+			deny:       func(method, kind, _ string, _ bool) bool { return method == http.MethodGet && kind == "metadata" },
+			step:       "stat",
+			capability: "read",
+		},
+		{
+			name:       "list",
+			deny:       func(method, _, _ string, _ bool) bool { return method == "LIST" },
+			step:       "list",
+			capability: "list",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage, vault := newTestStorage(t, &Config{WriteCustomMetadata: true})
+			vault.deny = test.deny
+
+			err := storage.Validate(context.Background())
+			if test.step == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v", err)
+				}
+			} else {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("Validate() = %v, want a ValidationError", err)
+				}
+				if validationErr.Step != test.step || validationErr.Capability != test.capability {
+					t.Errorf("Validate() failed at %q missing %q, want %q missing %q", validationErr.Step, validationErr.Capability, test.step, test.capability)
+				}
+			}
+
+			vault.deny = nil
+			if keys, err := storage.List(context.Background(), "", true); err == nil {
+				t.Errorf("Validate() left %q behind", keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package certmagic_vault_storage
+
+import (
+	"context"
+	. "fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// VersionInfo describes a single version of a key as kept by Vault's kv-v2 engine.
+type VersionInfo struct {
+	Version   int
+	Created   time.Time
+	Deleted   time.Time // zero unless the version has been deleted
+	Destroyed bool
+}
+
+// LoadVersion works like Load, but returns the value as it was at 'version' rather than the latest one.
+func (s *Storage) LoadVersion(ctx context.Context, key string, version int) (value []byte, err error) {
+	ctx, end := s.startOperation(ctx, OperationLoadVersion, key)
+	defer end(&err)
+
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	result, err := s.loadVersion(ctx, key, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.decodeValue(ctx, key, &result.Data.Data)
+}
+
+// ListVersions returns every version Vault still has metadata for, oldest first.  Deleted and destroyed versions are
+// included, with Deleted/Destroyed set accordingly.
+func (s *Storage) ListVersions(ctx context.Context, key string) (versions []VersionInfo, err error) {
+	ctx, end := s.startOperation(ctx, OperationListVersions, key)
+	defer end(&err)
+
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	result, err := s.readMetadata(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	versions = make([]VersionInfo, 0, len(result.Data.Versions))
+	for number, meta := range result.Data.Versions {
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			continue
+		}
+
+		info := VersionInfo{Version: version, Created: time.Time(meta.CreatedTime), Destroyed: meta.Destroyed}
+		if meta.deleted() {
+			info.Deleted = time.Time(meta.DeletionTime)
+		}
+		versions = append(versions, info)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
+
+	return versions, nil
+}
+
+// Rollback writes the contents of 'version' as a new, latest version of key.  Like 'vault kv rollback', history is
+// never rewritten, so a rollback can itself be rolled back.
+func (s *Storage) Rollback(ctx context.Context, key string, version int) (err error) {
+	ctx, end := s.startOperation(ctx, OperationRollback, key)
+	defer end(&err)
+
+	written := 0
+	defer s.audit(ctx, OperationRollback, key, &written, &err)
+
+	if err := validateKey(key); err != nil {
+		return err
+	}
+
+	previous, err := s.loadVersion(ctx, key, version)
+	if err != nil {
+		return err
+	}
+
+	// The key's metadata has to describe the value being restored
+	value, err := s.decodeValue(ctx, key, &previous.Data.Data)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Infow("Rolling back certificate", "key", key, "version", version)
+	result, err := s.writeSecret(ctx, key, &previous.Data.Data)
+	if err != nil {
+		return err
+	}
+	written = result.Data.Version
+
+	s.describeKey(ctx, key, value, result.Data.Version)
+
+	return nil
+}
+
+// loadVersion fetches the full secret stored at 'version' of key (0 means latest, as in the Vault API).
+func (s *Storage) loadVersion(ctx context.Context, key string, version int) (*response, error) {
+	s.logger.Debugw("loadVersion() from url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)), "version", version)
+
+	result := &response{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.GetVersion(ctx, s.getToken(ctx), s.vaultDataPath(key), version, result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to load certificate version",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"version", version,
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, newVaultError(s.vaultDataPath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
+		return nil, newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to load certificate version",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"version", version,
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
+	}
+
+	return result, nil
+}
+
+// readMetadata fetches the kv-v2 metadata of key, which includes its current version and the state of every version.
+func (s *Storage) readMetadata(ctx context.Context, key string) (*metadataResponse, error) {
+	s.logger.Debugw("readMetadata() at url", "url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)))
+
+	result := &metadataResponse{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.Get(ctx, s.getToken(ctx), s.vaultMetadataPath(key), result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to read certificate metadata",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)),
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, newVaultError(s.vaultMetadataPath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() && resp.StatusCode() == http.StatusNotFound {
+		return nil, newVaultError(s.vaultMetadataPath(key), resp, errResponse, nil)
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to read certificate metadata",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultMetadataPath(key)),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, newVaultError(s.vaultMetadataPath(key), resp, errResponse, nil)
+	}
+
+	return result, nil
+}
+
+// writeSecret stores secret as the new latest version of key as-is, using check-and-set if configured
+func (s *Storage) writeSecret(ctx context.Context, key string, secret *certificateSecret) (*writeResponse, error) {
+	cas, err := s.casVersion(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.writeSecretCAS(ctx, key, secret, cas)
+}
+
+// writeSecretCAS stores secret as the new latest version of key as-is.  When cas isn't nil, the write fails with
+// ErrCheckAndSet unless *cas is still the latest version of key.
+func (s *Storage) writeSecretCAS(ctx context.Context, key string, secret *certificateSecret, cas *int) (*writeResponse, error) {
+	result := &writeResponse{}
+	errResponse := &errorResponse{}
+	resp, err := s.client.PostCAS(ctx, s.getToken(ctx), s.vaultDataPath(key), secret, cas, result, errResponse)
+	if err != nil {
+		s.logger.Errorw(
+			"[ERROR] Unable to write certificate",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"error", err.Error(),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, newVaultError(s.vaultDataPath(key), resp, errResponse, err)
+	}
+
+	if resp.IsError() {
+		s.logger.Errorw(
+			"[ERROR] Unable to write certificate",
+			"url", Sprintf("%s%s", s.config.GetVaultBaseUrl(), s.vaultDataPath(key)),
+			"vault_errors", s.vaultErrorString(errResponse),
+			"response_code", resp.StatusCode(),
+			"response_body", s.responseBody(resp),
+		)
+		return nil, newVaultError(s.vaultDataPath(key), resp, errResponse, nil)
+	}
+
+	return result, nil
+}